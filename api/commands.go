@@ -0,0 +1,426 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/searchspring/nebo/customer"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/incident"
+	"github.com/searchspring/nebo/nextopia"
+	"github.com/searchspring/nebo/productboard"
+	"github.com/searchspring/nebo/render"
+	"github.com/searchspring/nebo/salesforce"
+)
+
+// command describes a slash command, the aliases it answers to and what it needs to run
+type command struct {
+	Name         string
+	Aliases      []string
+	Title        string
+	Usage        []usage
+	TextRequired bool
+	Async        bool
+	Working      string
+	Requires     []*credential
+	Uses         []*credential
+	Run          func(env *envVars, s *slack.SlashCommand) ([]byte, error)
+}
+
+// usage is a single line of help text
+type usage struct {
+	Args        string
+	Description string
+}
+
+// credential is a backend a command cannot run without, or for Uses, one it can do without. Load
+// builds the DAO the first time it is needed and keeps it for later invocations of the same warm
+// instance.
+type credential struct {
+	Name string
+	Load func(env *envVars) bool
+}
+
+// missing is the error of a command that cannot run without the credential
+func (c *credential) missing() error {
+	return errors.New("missing required " + c.Name + " credentials")
+}
+
+var salesforceCredential = &credential{
+	Name: "Salesforce",
+	Load: func(env *envVars) bool {
+		if salesForceDAO == nil {
+			salesForceDAO = salesforce.NewDAO(env.SfURL, env.SfUser, env.SfPassword, env.SfToken, env.SfContractStartField, env.SfRenewalField)
+		}
+		return salesForceDAO != nil
+	},
+}
+
+var nextopiaCredential = &credential{
+	Name: "Nextopia",
+	Load: func(env *envVars) bool {
+		if nextopiaDAO == nil {
+			nextopiaDAO = nextopia.NewDAO(env.NxURL, env.NxUser, env.NxPassword, env.NxCacheTTL, env.NxCacheFile)
+		}
+		return nextopiaDAO != nil
+	},
+}
+
+var productboardCredential = &credential{
+	Name: "Productboard",
+	Load: func(env *envVars) bool {
+		if productboardDAO == nil {
+			productboardDAO = productboard.NewDAO(env.ProductboardToken)
+		}
+		return productboardDAO != nil
+	},
+}
+
+var incidentsCredential = &credential{
+	Name: "Incident store",
+	Load: func(env *envVars) bool {
+		if incidentStore == nil {
+			incidentStore = incident.NewRedisStore(env.FireStoreURL, env.FireStoreToken)
+		}
+		return incidentStore != nil
+	},
+}
+
+// featureSubmitted thanks people for a /feature request
+const featureSubmitted = "Feature request submitted! The Product team will be in touch."
+
+var commands = []*command{
+	{
+		Name:    "/nebo",
+		Aliases: []string{"/rep", "/alpha-nebo"},
+		Title:   "Nebo",
+		Usage: []usage{
+			{"shoes", "find all customers with shoe in the name"},
+			{"red wing \"shoe store\"", "find customers matching every word and quoted phrase"},
+			{"platform:magento rep:\"Ashley Hilton\" mrr>1000 active:true", "filter customers by platform, rep, MRR and status"},
+			{"show shoes.com", "show one account in detail, by website or tracking code, with its cases, opportunities, contacts and family"},
+			{"shopify", "show {" + strings.ToLower(strings.Join(salesforce.Platforms, ", ")) + "} clients sorted by MRR"},
+		},
+		TextRequired: true,
+		Async:        true,
+		Requires:     []*credential{salesforceCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			text := strings.TrimSpace(s.Text)
+			if strings.HasPrefix(strings.ToLower(text), salesforce.ShowPrefix) {
+				return salesForceDAO.Show(text[len(salesforce.ShowPrefix):])
+			}
+			return salesForceDAO.Query(s.Text, 0)
+		},
+	},
+	{
+		Name:  "/customer",
+		Title: "Customer",
+		Usage: []usage{
+			{"shoes.com", "search Salesforce and Nextopia together, one card per customer with its tracking code and Nextopia IDs"},
+		},
+		TextRequired: true,
+		Async:        true,
+		Uses:         []*credential{salesforceCredential, nextopiaCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			return lookupCustomer(env, s.Text, 0)
+		},
+	},
+	{
+		Name:    "/neboidnx",
+		Aliases: []string{"/neboid"},
+		Title:   "Neboid",
+		Usage: []usage{
+			{"<id prefix>", "find all active customers in the Nextopia system with an id that starts with this prefix"},
+			{"<id prefix> --all", "include inactive customers too"},
+			{"shoes.com", "find customers by name, ignoring case and punctuation, with suggestions for typos"},
+		},
+		TextRequired: true,
+		Async:        true,
+		Requires:     []*credential{nextopiaCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			return nextopiaDAO.Query(s.Text, 0)
+		},
+	},
+	{
+		Name:  "/neboidss",
+		Title: "Neboid",
+		Usage: []usage{
+			{"<id>", "find a customer with this ID in the Searchspring system"},
+		},
+		TextRequired: true,
+		Async:        true,
+		Requires:     []*credential{salesforceCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			return salesForceDAO.IDQuery(s.Text)
+		},
+	},
+	{
+		Name:  "/neboadmin",
+		Title: "Nebo admin",
+		Usage: []usage{
+			{"refresh nextopia", "download the Nextopia account table again instead of waiting for the cache to expire"},
+		},
+		TextRequired: true,
+		Async:        true,
+		Working:      "Refreshing Nextopia…",
+		Requires:     []*credential{nextopiaCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			if !isAdmin(env, s.UserID) {
+				return ephemeral("Sorry, only Nebo admins can do that."), nil
+			}
+			if strings.TrimSpace(s.Text) != "refresh nextopia" {
+				return ephemeral("I don't know how to `" + strings.TrimSpace(s.Text) + "`, try `" + s.Command + " help`"), nil
+			}
+			return nextopiaDAO.Refresh()
+		},
+	},
+	{
+		Name:  "/feature",
+		Title: "Feature",
+		Usage: []usage{
+			{"description of feature required", "submits a feature to the product team"},
+		},
+		TextRequired: true,
+		Async:        true,
+		Working:      "Submitting your feature request…",
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			note := sendSlackMessage(env.SlackOauthToken, s.Text, s.UserID)
+			if !productboardCredential.Load(env) {
+				return ephemeral(featureSubmitted), nil
+			}
+			response, err := productboardDAO.CreateNote(note)
+			if err != nil {
+				// the request is already in slack, failing here would only make people post it again
+				log.Println("filing feature request in productboard: " + err.Error())
+				return ephemeral(featureSubmitted + " I couldn't add it to Productboard just now, so they'll file it from the Slack post."), nil
+			}
+			return response, nil
+		},
+	},
+	{
+		Name:    "/fire",
+		Aliases: []string{fireDryRun},
+		Title:   "Fire",
+		Usage: []usage{
+			{"", "open a fire in a new channel with the responders invited and generate a checklist to handle it"},
+			{"sev1 checkout is down", "open a fire with a title and a severity from 1, the worst, to 4"},
+			{"sev1 checkout is down " + fireAnnounceFlag, "open a fire and announce it in <#" + fireAnnouncements + "> straight away"},
+			{fireAnnounceWord, "announce this channel's fire once it is clearly a real fire"},
+			{"update the deploy has been rolled back", "post an update in the announcement thread of this channel's fire"},
+		},
+		Async:   true,
+		Working: "On it…",
+		Uses:    []*credential{incidentsCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			if s.Command == fireDryRun {
+				return dryRunFire(env, s, time.Now())
+			}
+			// a fire can be fought without the incident store, but not updated or announced later
+			update, isUpdate := parseFireUpdate(s.Text)
+			if !incidentsCredential.Load(env) && (isUpdate || isFireAnnounce(s.Text)) {
+				return nil, incidentsCredential.missing()
+			}
+			if isUpdate {
+				return updateFire(env, s, update, time.Now())
+			}
+			if isFireAnnounce(s.Text) {
+				return announceOpenFire(env, s, time.Now())
+			}
+			return startFire(env, s, time.Now())
+		},
+	},
+	{
+		Name:  "/firedown",
+		Title: "Firedown",
+		Usage: []usage{
+			{"", "close this channel's fire, say how long it burned in its announcement thread and generate a checklist for when it is out"},
+		},
+		Async:    true,
+		Working:  "Putting the fire out…",
+		Requires: []*credential{incidentsCredential},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			return closeFire(env, s, time.Now())
+		},
+	},
+	{
+		Name:    "/meet",
+		Aliases: []string{"/meettest"},
+		Title:   "Meet",
+		Usage: []usage{
+			{"", "generate a random meet"},
+			{"name", "generate a meet with a name"},
+		},
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			return meetResponse(s.Text), nil
+		},
+	},
+}
+
+// findCommand returns the command registered under name or one of its aliases
+func findCommand(name string) *command {
+	for _, c := range commands {
+		if c.Name == name {
+			return c
+		}
+		for _, alias := range c.Aliases {
+			if alias == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// respond writes the command's response. Async commands are acknowledged straight away and the work
+// is handed off to a second invocation, which posts the result to the response URL. If the work
+// cannot be handed off it is done here, and slack may say the command timed out before the result
+// arrives.
+func (c *command) respond(w http.ResponseWriter, r *http.Request, env *envVars, s *slack.SlashCommand) {
+	if !c.Async || c.wantsHelp(s) || s.ResponseURL == "" {
+		responseJSON, err := c.execute(env, s)
+		if err != nil {
+			sendInternalServerError(w, err)
+			return
+		}
+		w.Write(responseJSON)
+		return
+	}
+	if isDeferred(r) {
+		c.finish(env, s)
+		return
+	}
+	w.Write(c.searching(s.Text))
+	if !handOff(r) {
+		c.finish(env, s)
+	}
+}
+
+// finish runs an async command and posts the result, or a friendly failure, to the response URL.
+// Commands that have already posted their own result return nothing.
+func (c *command) finish(env *envVars, s *slack.SlashCommand) {
+	responseJSON, err := c.execute(env, s)
+	if err != nil {
+		log.Println(err.Error())
+		responseJSON = c.failure(s.Text, err)
+	}
+	if responseJSON == nil {
+		return
+	}
+	err = postSlackResponse(s.ResponseURL, responseJSON)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// execute runs the command after handling help and checking its credentials
+func (c *command) execute(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+	if c.wantsHelp(s) {
+		return c.help(s.Command), nil
+	}
+	for _, cred := range c.Requires {
+		if !cred.Load(env) {
+			return nil, cred.missing()
+		}
+	}
+	return c.Run(env, s)
+}
+
+func (c *command) wantsHelp(s *slack.SlashCommand) bool {
+	text := strings.TrimSpace(s.Text)
+	return text == "help" || (text == "" && c.TextRequired)
+}
+
+// searching acknowledges an async command while it runs
+func (c *command) searching(search string) []byte {
+	if c.Working != "" {
+		return ephemeral(c.Working)
+	}
+	return ephemeral("Searching " + c.backends() + " for `" + strings.TrimSpace(search) + "`…")
+}
+
+// failure is posted in place of the result when an async command fails
+func (c *command) failure(search string, err error) []byte {
+	if name, ok := httpclient.IsDown(err); ok {
+		return downMessage(name)
+	}
+	if c.Working != "" {
+		return ephemeral("Sorry, I couldn't reach " + c.backends() + " just now. Please try again in a minute.")
+	}
+	return ephemeral("Sorry, I couldn't search " + c.backends() + " for `" + strings.TrimSpace(search) + "` just now. Please try again in a minute.")
+}
+
+// lookupCustomer searches whichever of salesforce and nextopia nebo can sign in to, a backend it
+// cannot sign in to is reported the same way as one that fails to answer
+func lookupCustomer(env *envVars, search string, offset int) ([]byte, error) {
+	salesforceCredential.Load(env)
+	nextopiaCredential.Load(env)
+	return customer.Lookup(salesForceDAO, nextopiaDAO, search, offset)
+}
+
+// isAdmin reports whether the user may run admin commands. Nobody may if no admins are configured.
+func isAdmin(env *envVars, userID string) bool {
+	for _, admin := range env.NeboAdmins {
+		if strings.TrimSpace(admin) == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// downMessage explains that a backend is failing fast because it has been failing
+func downMessage(name string) []byte {
+	return ephemeral(name + " is down, so I've stopped asking it for a little while. Please try again in a few minutes.")
+}
+
+func ephemeral(text string) []byte {
+	json, _ := json.Marshal(render.Ephemeral(text))
+	return json
+}
+
+func (c *command) backends() string {
+	names := []string{}
+	for _, cred := range append(append([]*credential{}, c.Requires...), c.Uses...) {
+		names = append(names, cred.Name)
+	}
+	return strings.Join(names, " and ")
+}
+
+// help builds the usage message for the command as it was invoked
+func (c *command) help(invokedAs string) []byte {
+	if invokedAs == "" {
+		invokedAs = c.Name
+	}
+	lines := []string{c.Title + " usage:"}
+	for _, u := range c.Usage {
+		lines = append(lines, usageLine(invokedAs, u))
+	}
+	lines = append(lines, usageLine(invokedAs, usage{"help", "this message"}))
+	if c.Name == "/nebo" {
+		lines = append(lines, "", "Other commands:")
+		for _, other := range commands {
+			if other == c || len(other.Usage) == 0 {
+				continue
+			}
+			lines = append(lines, usageLine(other.Name, other.Usage[0]))
+		}
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         strings.Join(lines, "\n"),
+	}
+	json, _ := json.Marshal(msg)
+	return json
+}
+
+func usageLine(name string, u usage) string {
+	if u.Args == "" {
+		return "`" + name + "` - " + u.Description
+	}
+	return "`" + name + " " + u.Args + "` - " + u.Description
+}
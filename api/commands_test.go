@@ -0,0 +1,331 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/nextopia"
+	"github.com/searchspring/nebo/productboard"
+	"github.com/searchspring/nebo/salesforce"
+	"github.com/simpleforce/simpleforce"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindCommandAliases(t *testing.T) {
+	for _, name := range []string{"/nebo", "/rep", "/alpha-nebo"} {
+		require.Equal(t, "/nebo", findCommand(name).Name)
+	}
+	require.Equal(t, "/fire", findCommand("/firetest").Name)
+	require.Equal(t, "/neboidnx", findCommand("/neboid").Name)
+	require.Equal(t, "/meet", findCommand("/meettest").Name)
+	require.Nil(t, findCommand("/unknown"))
+}
+
+func TestCommandNamesAreUnique(t *testing.T) {
+	seen := map[string]bool{}
+	for _, c := range commands {
+		for _, name := range append([]string{c.Name}, c.Aliases...) {
+			require.False(t, seen[name], name)
+			seen[name] = true
+		}
+	}
+}
+
+func TestCommandHelp(t *testing.T) {
+	for _, text := range []string{"help", " help ", ""} {
+		response, err := findCommand("/rep").execute(&envVars{}, &slack.SlashCommand{Command: "/rep", Text: text})
+		require.Nil(t, err)
+		msg := &slack.Msg{}
+		require.Nil(t, json.Unmarshal(response, msg))
+		require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+		require.True(t, strings.HasPrefix(msg.Text, "Nebo usage:\n`/rep shoes` - find all customers with shoe in the name"))
+		require.True(t, strings.Contains(msg.Text, "`/rep help` - this message"))
+		require.True(t, strings.Contains(msg.Text, "`/meet` - generate a random meet"))
+	}
+}
+
+func TestCommandMissingCredentials(t *testing.T) {
+	salesForceDAO = nil
+	_, err := findCommand("/neboidss").execute(&envVars{}, &slack.SlashCommand{Command: "/neboidss", Text: "m6umjp"})
+	require.EqualError(t, err, "missing required Salesforce credentials")
+}
+
+func TestMeetCommand(t *testing.T) {
+	response, err := findCommand("/meettest").execute(&envVars{}, &slack.SlashCommand{Command: "/meettest", Text: "stand up"})
+	require.Nil(t, err)
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.Equal(t, "g.co/meet/stand-up", msg.Text)
+}
+
+func TestCommandsOnlyLoadTheDAOsTheyNeed(t *testing.T) {
+	salesForceDAO = nil
+	nextopiaDAO = nil
+	productboardDAO = nil
+	_, err := findCommand("/meet").execute(&envVars{SfURL: "https://example.my.salesforce.com"}, &slack.SlashCommand{Command: "/meet"})
+	require.Nil(t, err)
+	require.Nil(t, salesForceDAO)
+	require.Nil(t, nextopiaDAO)
+	require.Nil(t, productboardDAO)
+
+	_, err = findCommand("/feature").execute(&envVars{ProductboardToken: "token"}, &slack.SlashCommand{Command: "/feature", Text: "help"})
+	require.Nil(t, err)
+	require.Nil(t, productboardDAO)
+}
+
+type fakeSalesforceDAO struct {
+	response []byte
+	err      error
+	search   string
+	offset   int
+}
+
+func (f *fakeSalesforceDAO) Query(search string, offset int) ([]byte, error) {
+	f.search = search
+	f.offset = offset
+	return f.response, f.err
+}
+func (f *fakeSalesforceDAO) IDQuery(search string) ([]byte, error) { return f.response, f.err }
+func (f *fakeSalesforceDAO) Show(term string) ([]byte, error) {
+	f.search = "show:" + term
+	return f.response, f.err
+}
+func (f *fakeSalesforceDAO) Accounts(search string) ([]*salesforce.Account, error) {
+	return nil, f.err
+}
+func (f *fakeSalesforceDAO) ResultToMessage(search string, offset int, result *simpleforce.QueryResult) ([]byte, error) {
+	return f.response, f.err
+}
+
+func responseURLServer(t *testing.T, posted chan *slack.Msg) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := &slack.Msg{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(msg))
+		posted <- msg
+	}))
+}
+
+func TestAsyncCommandPostsResultToResponseURL(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	salesForceDAO = &fakeSalesforceDAO{response: []byte(`{"response_type":"in_channel","text":"Reps for search: shoes"}`)}
+	defer func() { salesForceDAO = nil }()
+
+	w := httptest.NewRecorder()
+	findCommand("/nebo").respond(w, deferredRequest(), &envVars{}, &slack.SlashCommand{Command: "/nebo", Text: "shoes", ResponseURL: server.URL})
+
+	msg := <-posted
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.Equal(t, "Reps for search: shoes", msg.Text)
+}
+
+func TestAsyncCommandPostsFriendlyFailure(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	salesForceDAO = &fakeSalesforceDAO{err: errors.New("INVALID_SESSION_ID")}
+	defer func() { salesForceDAO = nil }()
+
+	w := httptest.NewRecorder()
+	findCommand("/neboidss").respond(w, deferredRequest(), &envVars{}, &slack.SlashCommand{Command: "/neboidss", Text: "m6umjp", ResponseURL: server.URL})
+
+	require.Equal(t, http.StatusOK, w.Code)
+	msg := <-posted
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.True(t, strings.HasPrefix(msg.Text, "Sorry, I couldn't search Salesforce for `m6umjp`"))
+	require.False(t, strings.Contains(msg.Text, "INVALID_SESSION_ID"))
+}
+
+func TestAsyncCommandHelpIsImmediate(t *testing.T) {
+	w := httptest.NewRecorder()
+	findCommand("/neboid").respond(w, deferredRequest(), &envVars{}, &slack.SlashCommand{Command: "/neboid", Text: "help", ResponseURL: "http://127.0.0.1:0"})
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(w.Body.Bytes(), msg))
+	require.True(t, strings.HasPrefix(msg.Text, "Neboid usage:"))
+}
+
+// deferredRequest is a request handed off by a first invocation, which does the work itself
+func deferredRequest() *http.Request {
+	return deferred(httptest.NewRequest("POST", "/", nil))
+}
+
+func TestAsyncCommandAcknowledgesBeforeRunFinishes(t *testing.T) {
+	setTestEnv(t)
+	posted := make(chan *slack.Msg, 1)
+	responses := responseURLServer(t, posted)
+	defer responses.Close()
+	release := make(chan struct{})
+	commands = append(commands, &command{
+		Name:    "/slow",
+		Async:   true,
+		Working: "Working on it…",
+		Run: func(env *envVars, s *slack.SlashCommand) ([]byte, error) {
+			<-release
+			return ephemeral("Done"), nil
+		},
+	})
+	defer func() { commands = commands[:len(commands)-1] }()
+	server := httptest.NewServer(http.HandlerFunc(Handler))
+	defer server.Close()
+
+	body := url.Values{"command": {"/slow"}, "text": {"now"}, "response_url": {responses.URL}}.Encode()
+	r, err := http.NewRequest("POST", server.URL, strings.NewReader(body))
+	require.Nil(t, err)
+	r.Header = signedRequest(body, time.Now(), testSigningSecret).Header
+	res, err := http.DefaultClient.Do(r)
+	require.Nil(t, err)
+	defer res.Body.Close()
+	ack := &slack.Msg{}
+	require.Nil(t, json.NewDecoder(res.Body).Decode(ack))
+	require.Equal(t, "Working on it…", ack.Text)
+
+	select {
+	case <-posted:
+		t.Fatal("the result was posted before the command finished")
+	default:
+	}
+	close(release)
+	require.Equal(t, "Done", (<-posted).Text)
+}
+
+type fakeNextopiaDAO struct {
+	refreshes int
+}
+
+func (f *fakeNextopiaDAO) Query(search string, offset int) ([]byte, error) { return nil, nil }
+func (f *fakeNextopiaDAO) Accounts(search string) ([]*nextopia.Account, error) {
+	return nil, nil
+}
+func (f *fakeNextopiaDAO) Refresh() ([]byte, error) {
+	f.refreshes++
+	return []byte(`{"text":"Refreshed"}`), nil
+}
+
+func TestCustomerShowsNextopiaWhenSalesforceCannotSignIn(t *testing.T) {
+	nextopiaDAO = &fakeNextopiaDAO{}
+	defer func() { nextopiaDAO = nil }()
+	salesForceDAO = nil
+
+	c := findCommand("/customer")
+	require.Equal(t, "Searching Salesforce and Nextopia for `shoes`…", decodeMsg(t, c.searching("shoes")).Text)
+	response, err := c.execute(&envVars{}, &slack.SlashCommand{Command: "/customer", Text: "shoes"})
+	require.Nil(t, err)
+	require.Equal(t, "No customers match: shoes\n:warning: I couldn't sign in to Salesforce, showing Nextopia results only.", decodeMsg(t, response).Text)
+	require.Nil(t, salesForceDAO)
+}
+
+func TestAdminRefreshNextopia(t *testing.T) {
+	dao := &fakeNextopiaDAO{}
+	nextopiaDAO = dao
+	defer func() { nextopiaDAO = nil }()
+	admin := findCommand("/neboadmin")
+	env := &envVars{NeboAdmins: []string{"U1", "U2"}}
+
+	response, err := admin.execute(env, &slack.SlashCommand{Command: "/neboadmin", Text: "refresh nextopia", UserID: "U3"})
+	require.Nil(t, err)
+	require.Contains(t, string(response), "only Nebo admins")
+	require.Equal(t, 0, dao.refreshes)
+
+	response, err = admin.execute(env, &slack.SlashCommand{Command: "/neboadmin", Text: "refresh nextopia", UserID: "U2"})
+	require.Nil(t, err)
+	require.Equal(t, `{"text":"Refreshed"}`, string(response))
+	require.Equal(t, 1, dao.refreshes)
+
+	response, err = admin.execute(&envVars{}, &slack.SlashCommand{Command: "/neboadmin", Text: "refresh nextopia", UserID: "U2"})
+	require.Nil(t, err)
+	require.Contains(t, string(response), "only Nebo admins")
+	require.Equal(t, 1, dao.refreshes)
+
+	ack := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(admin.searching("refresh nextopia"), ack))
+	require.Equal(t, "Refreshing Nextopia…", ack.Text)
+}
+
+func TestAsyncCommandSaysWhenBackendIsDown(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	salesForceDAO = &fakeSalesforceDAO{err: &url.Error{Op: "Get", URL: "https://example.my.salesforce.com", Err: &httpclient.DownError{Name: "Salesforce"}}}
+	defer func() { salesForceDAO = nil }()
+
+	w := httptest.NewRecorder()
+	findCommand("/neboidss").respond(w, deferredRequest(), &envVars{}, &slack.SlashCommand{Command: "/neboidss", Text: "m6umjp", ResponseURL: server.URL})
+
+	msg := <-posted
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.True(t, strings.HasPrefix(msg.Text, "Salesforce is down"))
+}
+
+type fakeProductboardDAO struct {
+	err  error
+	note *productboard.Note
+}
+
+func (f *fakeProductboardDAO) CreateNote(note *productboard.Note) ([]byte, error) {
+	f.note = note
+	if f.err != nil {
+		return nil, f.err
+	}
+	return []byte(`{"response_type":"ephemeral","text":"Feature request submitted! <https://pb|View in Productboard>"}`), nil
+}
+
+func TestFeatureFilesTheSlackPostInProductboard(t *testing.T) {
+	fake := newFakeSlack(t, nil)
+	dao := &fakeProductboardDAO{}
+	productboardDAO = dao
+	defer func() { productboardDAO = nil }()
+
+	response, err := findCommand("/feature").execute(&envVars{}, &slack.SlashCommand{Command: "/feature", Text: "dark mode", UserID: "U1"})
+	require.Nil(t, err)
+	require.Equal(t, "Feature request submitted! <https://pb|View in Productboard>", decodeMsg(t, response).Text)
+	require.Equal(t, "<@U1> requests: dark mode", fake.called("chat.postMessage")[0].Get("text"))
+	require.Equal(t, "https://searchspring.slack.com/archives/G013YLWL3EX/p1603980505000100", dao.note.SourceURL)
+}
+
+func TestFeatureIsAcknowledgedFirstAndPostsItsResultOnce(t *testing.T) {
+	posted := make(chan *slack.Msg, 2)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	productboardDAO = nil
+	require.True(t, findCommand("/feature").Async)
+
+	feature := &slack.SlashCommand{Command: "/feature", Text: "dark mode", UserID: "U1", ResponseURL: server.URL}
+	findCommand("/feature").respond(httptest.NewRecorder(), deferredRequest(), &envVars{}, feature)
+	require.Len(t, posted, 1)
+	require.Equal(t, featureSubmitted, (<-posted).Text)
+	require.Len(t, fake.called("chat.postMessage"), 1)
+}
+
+func TestFeatureWithoutProductboardStillPostsToSlack(t *testing.T) {
+	fake := newFakeSlack(t, nil)
+	productboardDAO = nil
+
+	response, err := findCommand("/feature").execute(&envVars{}, &slack.SlashCommand{Command: "/feature", Text: "dark mode", UserID: "U1"})
+	require.Nil(t, err)
+	require.Equal(t, "Feature request submitted! The Product team will be in touch.", decodeMsg(t, response).Text)
+	require.Len(t, fake.called("chat.postMessage"), 1)
+	require.Nil(t, productboardDAO)
+}
+
+func TestFeatureReportsProductboardFailure(t *testing.T) {
+	newFakeSlack(t, nil)
+	productboardDAO = &fakeProductboardDAO{err: errors.New("productboard returned 500")}
+	defer func() { productboardDAO = nil }()
+
+	response, err := findCommand("/feature").execute(&envVars{}, &slack.SlashCommand{Command: "/feature", Text: "dark mode", UserID: "U1"})
+	require.Nil(t, err)
+	msg := decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.Equal(t, "Feature request submitted! The Product team will be in touch. I couldn't add it to Productboard just now, so they'll file it from the Slack post.", msg.Text)
+}
@@ -0,0 +1,539 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/searchspring/nebo/gdrive"
+	"github.com/searchspring/nebo/incident"
+	"github.com/searchspring/nebo/render"
+)
+
+// fireResponders is the usergroup invited to every fire channel
+const fireResponders = "S01DXD4HKCH"
+
+// fireAnnouncements is the channel the rest of the company follows fires in
+const fireAnnouncements = "C024FV14Z"
+
+// fireDryRun is the alias of /fire that only says what /fire would do, so it can be tried out without
+// creating channels and inviting the responders
+const fireDryRun = "/firetest"
+
+// fireUpdateWord starts a /fire that posts an update instead of opening a fire
+const fireUpdateWord = "update"
+
+// fireAnnounceWord starts a /fire that announces the channel's fire instead of opening one
+const fireAnnounceWord = "announce"
+
+// fireAnnounceFlag opens a fire that is announced straight away, for when it is clearly a real fire
+const fireAnnounceFlag = "--announce"
+
+// fireRolesBlockID identifies the buttons people take a role in a fire with
+const fireRolesBlockID = "fire_roles"
+
+// fireRoleButtons are the roles a fire needs filled, in the order they are shown
+var fireRoleButtons = []struct {
+	Role     string
+	ActionID string
+	Label    string
+	Button   string
+}{
+	{incident.RoleLeader, "fire_lead", "Leader", "I'll lead"},
+	{incident.RoleDocMaintainer, "fire_doc", "Doc maintainer", "I'll maintain the doc"},
+	{incident.RoleAnnouncer, "fire_announce", "Announcer", "I'll announce"},
+}
+
+// maxChannelName is the longest channel name slack accepts
+const maxChannelName = 80
+
+// channelNameUnsafe matches runs of characters slack does not allow in a channel name
+var channelNameUnsafe = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// slackAPIURL points the slack api client somewhere other than slack, for tests
+var slackAPIURL = ""
+
+// newSlackAPI returns a slack api client that calls slack through slackClient
+func newSlackAPI(token string) *slack.Client {
+	options := []slack.Option{slack.OptionHTTPClient(slackClient)}
+	if slackAPIURL != "" {
+		options = append(options, slack.OptionAPIURL(slackAPIURL))
+	}
+	return slack.New(token, options...)
+}
+
+// startFire opens an incident in a channel of its own, with the responders invited, a fire doc copied
+// from the template and the meet and doc in the topic, and posts the checklist there. If the channel cannot be created the fire is
+// fought in the channel /fire was run in instead. If the incident store cannot be reached the fire is
+// still fought, without an ID or role buttons.
+func startFire(env *envVars, s *slack.SlashCommand, now time.Time) ([]byte, error) {
+	text, announce := parseFireFlags(s.Text)
+	title, severity := incident.ParseSeverity(text)
+	title = cleanFireTitle(title)
+	store := incidentStore
+	if store != nil {
+		existing, err := store.OpenIn(s.ChannelID)
+		if err == nil {
+			return alreadyBurning(existing), nil
+		}
+		if err != incident.ErrNotFound {
+			log.Println("finding open fire: " + err.Error())
+			store = nil
+		}
+	}
+
+	meet := getMeetLink("fire-investigation-" + timestamp(now))
+	api := newSlackAPI(env.SlackOauthToken)
+	channelID, err := createFireChannel(api, fireChannelName(title, now), s.UserID)
+	if err != nil {
+		log.Println("creating fire channel: " + err.Error())
+		channelID = s.ChannelID
+	}
+
+	i, err := openFire(store, title, channelID, s.UserID, severity, now)
+	if err == incident.ErrAlreadyOpen {
+		return alreadyBurning(i), nil
+	}
+	i.Meet = meet
+	i.Leader = s.UserID
+	i.Doc = createFireDoc(env, api, i)
+	if channelID != s.ChannelID {
+		_, err = api.SetTopicOfConversation(channelID, "Meet: "+meet+" | Doc: "+fireDocLink(env, i))
+		if err != nil {
+			log.Println("setting fire channel topic: " + err.Error())
+		}
+	}
+	if announce {
+		i.Announcement = announceFire(api, i)
+	}
+	if i.ID != "" {
+		err = store.Save(i)
+		if err != nil {
+			log.Println("saving fire: " + err.Error())
+		}
+	}
+
+	msg := fireMessage(env.GdriveFireDocFolderID, i)
+	warnings := []string{}
+	if i.ID == "" {
+		warnings = append(warnings, ":warning: I couldn't save this fire, so there are no role buttons and `/fire update` and `/firedown` won't know about it.")
+	}
+	if channelID == s.ChannelID {
+		warnings = append(warnings, ":warning: I couldn't create a channel for this fire, so let's fight it here.")
+	}
+	if len(warnings) > 0 {
+		warning := strings.Join(warnings, "\n")
+		msg.Text = warning + "\n" + msg.Text
+		msg.Blocks.BlockSet = append([]slack.Block{slack.NewSectionBlock(render.Markdown(warning), nil, nil)}, msg.Blocks.BlockSet...)
+	}
+	if channelID == s.ChannelID {
+		responseJSON, err := json.Marshal(msg)
+		if err != nil {
+			return nil, err
+		}
+		postSlackResponse(s.ResponseURL, responseJSON)
+		return nil, nil
+	}
+	_, _, err = api.PostMessage(channelID, slack.MsgOptionText(msg.Text, false), slack.MsgOptionBlocks(msg.Blocks.BlockSet...))
+	if err != nil {
+		log.Println("posting fire checklist: " + err.Error())
+	}
+	postSlackMessage(s.ResponseURL, slack.ResponseTypeInChannel, fireHeader(i)+"Join the fight in <#"+channelID+">")
+	return nil, nil
+}
+
+// openFire creates the fire in the store. Without a store, or if the store fails, the fire is
+// returned without an ID so it can still be fought.
+func openFire(store incident.Store, title string, channelID string, user string, severity int, now time.Time) (*incident.Incident, error) {
+	if store != nil {
+		i, err := incident.Open(store, title, channelID, user, severity, now)
+		if err == nil || err == incident.ErrAlreadyOpen {
+			return i, err
+		}
+		log.Println("opening fire: " + err.Error())
+	}
+	return incident.New(title, channelID, user, severity, now), nil
+}
+
+// dryRunFire says what /fire would do with the text, and shows the checklist it would post, without
+// doing any of it
+func dryRunFire(env *envVars, s *slack.SlashCommand, now time.Time) ([]byte, error) {
+	if update, ok := parseFireUpdate(s.Text); ok {
+		return ephemeral("Dry run, nothing was posted. `/fire` would post this update in the announcement thread of this channel's fire: " + update), nil
+	}
+	if isFireAnnounce(s.Text) {
+		return ephemeral("Dry run, nothing was posted. `/fire` would announce this channel's fire in <#" + fireAnnouncements + ">"), nil
+	}
+	text, announce := parseFireFlags(s.Text)
+	title, severity := incident.ParseSeverity(text)
+	title = cleanFireTitle(title)
+	i := &incident.Incident{ID: "FIRE-?", Title: title, Severity: severity, Status: incident.StatusOpen, Started: now, Leader: s.UserID}
+	i.Log(now, s.UserID, "opened the fire")
+	doc := "link the fire doc folder, there is no fire doc template"
+	if env.GdriveFireTemplateID != "" {
+		doc = "copy the fire doc template into the fire doc folder"
+	}
+	announcement := "not announce it, add `" + fireAnnounceFlag + "` or use `/fire " + fireAnnounceWord + "` later if it is a real fire"
+	if announce {
+		announcement = "announce it in <#" + fireAnnouncements + ">"
+	}
+	steps := []string{
+		"create #" + fireChannelName(title, now) + " and invite <!subteam^" + fireResponders + "> and you",
+		doc,
+		announcement,
+		"post this checklist there",
+	}
+	return ephemeral("Dry run, nothing was created. `/fire` would:\n• " + strings.Join(steps, "\n• ") + "\n\n" +
+		fireHeader(i) + fireChecklist(env.GdriveFireDocFolderID, i)), nil
+}
+
+// fireMessage is the checklist with who holds each role, and buttons to take them while the fire
+// is burning
+func fireMessage(folderID string, i *incident.Incident) *slack.Msg {
+	text := fireHeader(i) + fireChecklist(folderID, i)
+	roles := []string{}
+	buttons := []slack.BlockElement{}
+	for _, r := range fireRoleButtons {
+		holder := "nobody yet"
+		if user := i.Holder(r.Role); user != "" {
+			holder = "<@" + user + ">"
+		}
+		roles = append(roles, "*"+r.Label+":* "+holder)
+		buttons = append(buttons, slack.NewButtonBlockElement(r.ActionID, i.ID, slack.NewTextBlockObject(slack.PlainTextType, r.Button, false, false)))
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         text,
+	}
+	msg.Blocks.BlockSet = []slack.Block{
+		slack.NewSectionBlock(render.Markdown(text), nil, nil),
+		slack.NewSectionBlock(render.Markdown(strings.Join(roles, "\n")), nil, nil),
+	}
+	if i.Status == incident.StatusOpen && i.ID != "" {
+		msg.Blocks.BlockSet = append(msg.Blocks.BlockSet, slack.NewActionBlock(fireRolesBlockID, buttons...))
+	}
+	return msg
+}
+
+// takeFireRole gives the user the role of the button they clicked and redraws the fire message.
+// Once the fire is out the roles no longer change.
+func takeFireRole(env *envVars, user string, action *slack.BlockAction, now time.Time) ([]byte, error) {
+	role := ""
+	for _, r := range fireRoleButtons {
+		if r.ActionID == action.ActionID {
+			role = r.Role
+		}
+	}
+	if role == "" {
+		return nil, errors.New("unknown fire role button " + action.ActionID)
+	}
+	i, err := incidentStore.Get(action.Value)
+	if err != nil {
+		return nil, err
+	}
+	if i.Status == incident.StatusOpen {
+		err = i.Assign(role, user, now)
+		if err != nil {
+			return nil, err
+		}
+		err = incidentStore.Save(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return json.Marshal(fireMessage(env.GdriveFireDocFolderID, i))
+}
+
+// announceFire posts the fire to the announcements channel, returning the timestamp of the post that
+// updates are threaded under, or "" if it could not be posted
+func announceFire(api *slack.Client, i *incident.Incident) string {
+	text := ":fire: There is a fire and engineering is investigating, updates will be posted in a thread on this message\n*" + i.Name() + "*"
+	if i.Channel != "" {
+		text += " in <#" + i.Channel + ">"
+	}
+	if i.Doc != "" {
+		text += " · <" + i.Doc + "|fire doc>"
+	}
+	_, ts, err := api.PostMessage(fireAnnouncements, slack.MsgOptionText(text, false))
+	if err != nil {
+		log.Println("announcing fire: " + err.Error())
+		return ""
+	}
+	return ts
+}
+
+// parseFireUpdate returns the text of an update, or false if the text opens a fire
+func parseFireUpdate(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 || !strings.EqualFold(fields[0], fireUpdateWord) {
+		return "", false
+	}
+	return strings.TrimSpace(text[len(fields[0]):]), true
+}
+
+// isFireAnnounce reports whether the text asks to announce the channel's fire
+func isFireAnnounce(text string) bool {
+	return strings.EqualFold(strings.TrimSpace(text), fireAnnounceWord)
+}
+
+// parseFireFlags takes fireAnnounceFlag off the text, reporting whether it was there
+func parseFireFlags(text string) (string, bool) {
+	words := []string{}
+	announce := false
+	for _, word := range strings.Fields(text) {
+		if strings.EqualFold(word, fireAnnounceFlag) {
+			announce = true
+			continue
+		}
+		words = append(words, word)
+	}
+	return strings.Join(words, " "), announce
+}
+
+// announceOpenFire announces the channel's fire, for when it turns out to be a real one
+func announceOpenFire(env *envVars, s *slack.SlashCommand, now time.Time) ([]byte, error) {
+	i, err := incidentStore.OpenIn(s.ChannelID)
+	if err == incident.ErrNotFound {
+		return noFireBurning(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.Announcement != "" {
+		return ephemeral("*" + i.Name() + "* has already been announced in <#" + fireAnnouncements + ">, post updates in its thread with `/fire update <text>`"), nil
+	}
+	i.Announcement = announceFire(newSlackAPI(env.SlackOauthToken), i)
+	if i.Announcement == "" {
+		return nil, errors.New("could not announce " + i.ID)
+	}
+	i.Log(now, s.UserID, "announced the fire")
+	err = incidentStore.Save(i)
+	if err != nil {
+		return nil, err
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         ":loudspeaker: <@" + s.UserID + "> announced *" + i.Name() + "* in <#" + fireAnnouncements + ">, post updates in its thread with `/fire update <text>`",
+	}
+	return json.Marshal(msg)
+}
+
+// updateFire posts the text in the announcement thread of the channel's fire
+func updateFire(env *envVars, s *slack.SlashCommand, text string, now time.Time) ([]byte, error) {
+	if text == "" {
+		return ephemeral("Tell me what to post, e.g. `/fire update the deploy has been rolled back`"), nil
+	}
+	i, err := incidentStore.OpenIn(s.ChannelID)
+	if err == incident.ErrNotFound {
+		return noFireBurning(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.Announcement == "" {
+		return ephemeral("*" + i.Name() + "* was never announced in <#" + fireAnnouncements + ">, so there is no thread to post updates in. Use `/fire " + fireAnnounceWord + "` if it is a real fire."), nil
+	}
+
+	api := newSlackAPI(env.SlackOauthToken)
+	_, _, err = api.PostMessage(fireAnnouncements, slack.MsgOptionText("<@"+s.UserID+">: "+text, false), slack.MsgOptionTS(i.Announcement))
+	if err != nil {
+		return nil, err
+	}
+	i.Log(now, s.UserID, "posted an update: "+text)
+	err = incidentStore.Save(i)
+	if err != nil {
+		return nil, err
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         ":loudspeaker: <@" + s.UserID + "> posted an update to <#" + fireAnnouncements + ">: " + text,
+	}
+	return json.Marshal(msg)
+}
+
+// closeFire resolves the channel's fire, replying in its announcement thread and marking the
+// announcement done
+func closeFire(env *envVars, s *slack.SlashCommand, now time.Time) ([]byte, error) {
+	i, err := incident.Close(incidentStore, s.ChannelID, s.UserID, now)
+	if err == incident.ErrNotFound {
+		return noFireBurning(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if i.Announcement != "" {
+		api := newSlackAPI(env.SlackOauthToken)
+		text := ":white_check_mark: *" + i.Name() + "* is out after " + incident.FormatDuration(i.Duration(i.Ended))
+		_, _, err = api.PostMessage(fireAnnouncements, slack.MsgOptionText(text, false), slack.MsgOptionTS(i.Announcement))
+		if err != nil {
+			log.Println("announcing fire is out: " + err.Error())
+		}
+		err = api.AddReaction("white_check_mark", slack.NewRefToMessage(fireAnnouncements, i.Announcement))
+		if err != nil {
+			log.Println("marking fire announcement done: " + err.Error())
+		}
+	}
+	return fireDownResponse(i), nil
+}
+
+// createFireDoc copies the fire doc template into the fire doc folder with the fire filled in,
+// returning a link to it, or "" if there is no template or it could not be copied
+func createFireDoc(env *envVars, api *slack.Client, i *incident.Incident) string {
+	if env.GdriveFireTemplateID == "" {
+		return ""
+	}
+	if gdriveDAO == nil {
+		gdriveDAO = gdrive.NewDAO(env.GdriveServiceAccount)
+	}
+	if gdriveDAO == nil {
+		log.Println("not creating a fire doc without a google service account")
+		return ""
+	}
+	leader := i.Leader
+	if user, err := api.GetUserInfo(i.Leader); err == nil && user.RealName != "" {
+		leader = user.RealName
+	}
+	link, err := gdriveDAO.CopyTemplate(env.GdriveFireTemplateID, env.GdriveFireDocFolderID, i.ID+" "+i.Title+" "+timestamp(i.Started), map[string]string{
+		"title":   i.Name(),
+		"started": i.Started.UTC().Format("2006-01-02 15:04 MST"),
+		"leader":  leader,
+		"meet":    i.Meet,
+	})
+	if err != nil {
+		log.Println("creating fire doc: " + err.Error())
+		return ""
+	}
+	return link
+}
+
+// fireDocLink is the fire's doc, or the folder to create one in if nebo could not
+func fireDocLink(env *envVars, i *incident.Incident) string {
+	if i.Doc != "" {
+		return i.Doc
+	}
+	return fmt.Sprintf("https://drive.google.com/drive/folders/%s", env.GdriveFireDocFolderID)
+}
+
+// createFireChannel creates the channel and invites the responders and whoever started the fire.
+// Only failing to create the channel is an error, the invites can be done by hand.
+func createFireChannel(api *slack.Client, name string, starter string) (string, error) {
+	channel, err := api.CreateConversation(name, false)
+	if err != nil {
+		return "", err
+	}
+
+	invite := []string{}
+	members, err := api.GetUserGroupMembers(fireResponders)
+	if err != nil {
+		log.Println("listing fire responders: " + err.Error())
+	}
+	self := ""
+	if auth, err := api.AuthTest(); err == nil {
+		self = auth.UserID
+	}
+	seen := map[string]bool{self: true}
+	for _, user := range append([]string{starter}, members...) {
+		if user != "" && !seen[user] {
+			seen[user] = true
+			invite = append(invite, user)
+		}
+	}
+	if len(invite) > 0 {
+		_, err = api.InviteUsersToConversation(channel.ID, invite...)
+		if err != nil {
+			log.Println("inviting fire responders: " + err.Error())
+		}
+	}
+	return channel.ID, nil
+}
+
+// fireChannelName turns the title into a channel name slack accepts, e.g. "Checkout is down!" at
+// 14:08 is fire-checkout-is-down-2020-10-29-14-08
+func fireChannelName(title string, now time.Time) string {
+	slug := strings.Trim(channelNameUnsafe.ReplaceAllString(strings.ToLower(title), "-"), "-")
+	suffix := "-" + timestamp(now)
+	room := maxChannelName - len("fire-") - len(suffix)
+	if len(slug) > room {
+		slug = strings.TrimRight(slug[:room], "-")
+	}
+	if slug == "" {
+		return "fire" + suffix
+	}
+	return "fire-" + slug + suffix
+}
+
+func noFireBurning() []byte {
+	return ephemeral("There is no fire burning in this channel, use `/fire` to open one")
+}
+
+func alreadyBurning(i *incident.Incident) []byte {
+	return ephemeral("*" + i.Name() + "* is already burning in this channel, use `/firedown` when it is out")
+}
+
+// fireHeader names the incident the checklist is for and who opened it
+func fireHeader(i *incident.Incident) string {
+	header := ":fire: *" + i.Name() + "*"
+	if len(i.Timeline) > 0 && i.Timeline[0].User != "" {
+		header += " opened by <@" + i.Timeline[0].User + ">"
+	}
+	return header + "\n"
+}
+
+func cleanFireTitle(title string) string {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		title = "New Fire"
+	}
+	return title
+}
+
+// fireChecklist is posted when a fire starts. Steps nebo has already done, creating the doc and
+// announcing the fire, are replaced by what is left for people to do.
+func fireChecklist(folderID string, i *incident.Incident) string {
+	doc := "3. Fire doc maintainer creates a new doc here: " + fmt.Sprintf("<https://drive.google.com/drive/folders/%s>", folderID) + "\n" +
+		"4. Post link to the fire doc\n"
+	if i.Doc != "" {
+		doc = "3. The fire doc is ready: <" + i.Doc + "|" + strings.TrimSpace(i.ID+" "+i.Title) + ">\n" +
+			"4. Fire doc maintainer keeps the fire doc up to date\n"
+	}
+	announce := "5. If a real fire - announcer announces it in <#" + fireAnnouncements + "> with `/fire " + fireAnnounceWord + "`\n" +
+		"6. Post a link to the fire document in the announcement thread with `/fire update`\n"
+	if i.Announcement != "" {
+		announce = "5. The fire has been announced in <#" + fireAnnouncements + ">, the announcer posts updates in its thread with `/fire update <text>`\n" +
+			"6. Post a link to the fire document with `/fire update`\n"
+	}
+	if i.Announcement != "" && i.Doc != "" {
+		announce = "5. The fire has been announced in <#" + fireAnnouncements + ">, the announcer posts updates in its thread with `/fire update <text>`\n" +
+			"6. The fire doc is linked in the announcement\n"
+	}
+	roles := "2. Take the roles of fire leader, document maintainer and announcements updater with the buttons below\n"
+	if i.ID == "" {
+		roles = "2. Agree who is fire leader, document maintainer and announcements updater\n"
+	}
+	text := "1. The <!subteam^" + fireResponders + "> have been invited to this channel\n" +
+		roles +
+		doc +
+		announce +
+		"7. Fight! " + i.Meet + "\n\n\n" +
+		"8. Use `/firedown` when the fire is out\n"
+	return text
+}
+
+func fireDownResponse(i *incident.Incident) []byte {
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text: ":white_check_mark: *" + i.Name() + "* is out after " + incident.FormatDuration(i.Duration(i.Ended)) + "\n" +
+			"1. Ask if there are any cleanup tasks to do\n" +
+			"2. Update the <#" + fireAnnouncements + ">  channel\n" +
+			"3. If applicable, schedule a blameless post mortem\n",
+	}
+	json, _ := json.Marshal(msg)
+	return json
+}
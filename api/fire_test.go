@@ -0,0 +1,676 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/incident"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSlack stands in for the slack web api, recording the form each method was called with
+type fakeSlack struct {
+	server *httptest.Server
+	failed map[string]string
+
+	mutex sync.Mutex
+	calls map[string][]url.Values
+}
+
+var fakeSlackResponses = map[string]string{
+	"conversations.create":   `{"ok":true,"channel":{"id":"CFIRE","name":"fire"}}`,
+	"usergroups.users.list":  `{"ok":true,"users":["U2","UBOT","U3"]}`,
+	"auth.test":              `{"ok":true,"user_id":"UBOT"}`,
+	"conversations.invite":   `{"ok":true,"channel":{"id":"CFIRE"}}`,
+	"conversations.setTopic": `{"ok":true,"channel":{"id":"CFIRE"}}`,
+	"chat.postMessage":       `{"ok":true,"channel":"CFIRE","ts":"1603980505.000100"}`,
+	"reactions.add":          `{"ok":true}`,
+	"users.info":             `{"ok":true,"user":{"id":"U1","real_name":"Pat Doe"}}`,
+	"chat.getPermalink":      `{"ok":true,"channel":"G013YLWL3EX","permalink":"https://searchspring.slack.com/archives/G013YLWL3EX/p1603980505000100"}`,
+}
+
+// newFakeSlack starts a fake slack and points the slack api client at it. Methods named in failed
+// answer with that error.
+func newFakeSlack(t *testing.T, failed map[string]string) *fakeSlack {
+	f := &fakeSlack{failed: failed, calls: map[string][]url.Values{}}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Nil(t, r.ParseForm())
+		method := strings.TrimPrefix(r.URL.Path, "/")
+		f.mutex.Lock()
+		f.calls[method] = append(f.calls[method], r.PostForm)
+		f.mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		if e, ok := f.failed[method]; ok {
+			w.Write([]byte(`{"ok":false,"error":"` + e + `"}`))
+			return
+		}
+		response, ok := fakeSlackResponses[method]
+		require.True(t, ok, "unexpected slack method "+method)
+		w.Write([]byte(response))
+	}))
+	slackAPIURL = f.server.URL + "/"
+	t.Cleanup(func() {
+		slackAPIURL = ""
+		f.server.Close()
+	})
+	return f
+}
+
+func (f *fakeSlack) called(method string) []url.Values {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[method]
+}
+
+// fakeRedis answers the redis REST API the shared incident store uses from a map
+func fakeRedis(t *testing.T) *httptest.Server {
+	keys := map[string]string{}
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		require.Equal(t, "Bearer redis-token", r.Header.Get("Authorization"))
+		args := []string{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&args))
+		var result interface{}
+		switch args[0] {
+		case "GET":
+			if value, ok := keys[args[1]]; ok {
+				result = value
+			}
+		case "SET":
+			_, exists := keys[args[1]]
+			if len(args) < 4 || (args[3] == "XX") == exists {
+				keys[args[1]] = args[2]
+				result = "OK"
+			}
+		case "DEL":
+			delete(keys, args[1])
+			result = 1
+		case "INCR":
+			n, _ := strconv.Atoi(keys[args[1]])
+			keys[args[1]] = strconv.Itoa(n + 1)
+			result = n + 1
+		default:
+			t.Errorf("unexpected redis command %s", args[0])
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// fireTestEnv gives each test its own incident store
+func fireTestEnv(t *testing.T) *envVars {
+	incidentStore = incident.NewFileStore(filepath.Join(t.TempDir(), "incidents.json"))
+	gdriveDAO = nil
+	t.Cleanup(func() {
+		incidentStore = nil
+		gdriveDAO = nil
+	})
+	return &envVars{
+		SlackOauthToken:       "token",
+		GdriveFireDocFolderID: "folder",
+	}
+}
+
+func decodeMsg(t *testing.T, response []byte) *slack.Msg {
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	return msg
+}
+
+func TestFireCreatesAChannel(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "sev1 Checkout is down! --announce", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	response, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	require.Nil(t, response)
+
+	created := fake.called("conversations.create")
+	require.Len(t, created, 1)
+	require.True(t, strings.HasPrefix(created[0].Get("name"), "fire-checkout-is-down-"))
+	require.Equal(t, "S01DXD4HKCH", fake.called("usergroups.users.list")[0].Get("usergroup"))
+	invited := fake.called("conversations.invite")
+	require.Len(t, invited, 1)
+	require.Equal(t, "CFIRE", invited[0].Get("channel"))
+	require.Equal(t, "U1,U2,U3", invited[0].Get("users"))
+	topic := fake.called("conversations.setTopic")[0].Get("topic")
+	require.True(t, strings.HasPrefix(topic, "Meet: g.co/meet/fire-investigation-"))
+	require.True(t, strings.HasSuffix(topic, " | Doc: https://drive.google.com/drive/folders/folder"))
+	posts := fake.called("chat.postMessage")
+	require.Len(t, posts, 2)
+	require.Equal(t, "C024FV14Z", posts[0].Get("channel"))
+	require.Equal(t, ":fire: There is a fire and engineering is investigating, updates will be posted in a thread on this message\n*FIRE-1 Checkout is down! (SEV1)* in <#CFIRE>", posts[0].Get("text"))
+	require.Equal(t, "CFIRE", posts[1].Get("channel"))
+	require.True(t, strings.HasPrefix(posts[1].Get("text"), ":fire: *FIRE-1 Checkout is down! (SEV1)* opened by <@U1>\n1. The <!subteam^S01DXD4HKCH> have been invited"))
+	blocks := []map[string]interface{}{}
+	require.Nil(t, json.Unmarshal([]byte(posts[1].Get("blocks")), &blocks))
+	require.Len(t, blocks, 3)
+	require.Equal(t, "fire_roles", blocks[2]["block_id"])
+	require.Contains(t, posts[1].Get("text"), "\n5. The fire has been announced in <#C024FV14Z>, the announcer posts updates in its thread with `/fire update <text>`\n")
+
+	msg := <-posted
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.Equal(t, ":fire: *FIRE-1 Checkout is down! (SEV1)* opened by <@U1>\nJoin the fight in <#CFIRE>", msg.Text)
+
+	i, err := incidentStore.OpenIn("CFIRE")
+	require.Nil(t, err)
+	require.Equal(t, "FIRE-1", i.ID)
+	require.True(t, strings.HasPrefix(i.Meet, "g.co/meet/fire-investigation-"))
+	require.Equal(t, "", i.Doc)
+	require.Equal(t, "U1", i.Leader)
+	require.Equal(t, "1603980505.000100", i.Announcement)
+}
+
+type fakeGdriveDAO struct {
+	link       string
+	err        error
+	templateID string
+	folderID   string
+	name       string
+	fields     map[string]string
+}
+
+func (f *fakeGdriveDAO) CopyTemplate(templateID string, folderID string, name string, fields map[string]string) (string, error) {
+	f.templateID, f.folderID, f.name, f.fields = templateID, folderID, name, fields
+	return f.link, f.err
+}
+
+func TestFireCreatesADocFromTheTemplate(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+	env.GdriveFireTemplateID = "template"
+	drive := &fakeGdriveDAO{link: "https://docs.google.com/document/d/doc1/edit"}
+	gdriveDAO = drive
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "sev1 checkout is down --announce", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	<-posted
+
+	require.Equal(t, "template", drive.templateID)
+	require.Equal(t, "folder", drive.folderID)
+	require.True(t, strings.HasPrefix(drive.name, "FIRE-1 checkout is down "))
+	require.Equal(t, "FIRE-1 checkout is down (SEV1)", drive.fields["title"])
+	require.Equal(t, "Pat Doe", drive.fields["leader"])
+	require.True(t, strings.HasPrefix(drive.fields["meet"], "g.co/meet/fire-investigation-"))
+	require.True(t, strings.HasSuffix(drive.fields["started"], " UTC"))
+	require.Equal(t, "U1", fake.called("users.info")[0].Get("user"))
+
+	topic := fake.called("conversations.setTopic")[0].Get("topic")
+	require.True(t, strings.HasSuffix(topic, " | Doc: https://docs.google.com/document/d/doc1/edit"))
+	posts := fake.called("chat.postMessage")
+	require.True(t, strings.HasSuffix(posts[0].Get("text"), " in <#CFIRE> · <https://docs.google.com/document/d/doc1/edit|fire doc>"))
+	require.Contains(t, posts[1].Get("text"), "\n3. The fire doc is ready: <https://docs.google.com/document/d/doc1/edit|FIRE-1 checkout is down>\n")
+	require.Contains(t, posts[1].Get("text"), "\n6. The fire doc is linked in the announcement\n")
+	i, err := incidentStore.Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, "https://docs.google.com/document/d/doc1/edit", i.Doc)
+}
+
+func TestFireLinksTheFolderWhenTheDocCannotBeCreated(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+	env.GdriveFireTemplateID = "template"
+	gdriveDAO = &fakeGdriveDAO{err: errors.New("google returned 404")}
+
+	fire := &slack.SlashCommand{Command: "/fire", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	<-posted
+	topic := fake.called("conversations.setTopic")[0].Get("topic")
+	require.True(t, strings.HasSuffix(topic, " | Doc: https://drive.google.com/drive/folders/folder"))
+	require.Contains(t, fake.called("chat.postMessage")[0].Get("text"), "\n3. Fire doc maintainer creates a new doc here: <https://drive.google.com/drive/folders/folder>\n")
+}
+
+func TestFireFallsBackToTheCurrentChannel(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, map[string]string{"conversations.create": "restricted_action"})
+	env := fireTestEnv(t)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "checkout is down --announce", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	posts := fake.called("chat.postMessage")
+	require.Len(t, posts, 1)
+	require.Equal(t, "C024FV14Z", posts[0].Get("channel"))
+	require.True(t, strings.HasSuffix(posts[0].Get("text"), "in <#C1>"))
+	msg := <-posted
+	require.True(t, strings.HasPrefix(msg.Text, ":warning: I couldn't create a channel for this fire, so let's fight it here.\n:fire: *FIRE-1 checkout is down (SEV2)*"))
+	_, err = incidentStore.OpenIn("C1")
+	require.Nil(t, err)
+}
+
+func TestFireKeepsGoingWhenInvitesFail(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, map[string]string{"usergroups.users.list": "missing_scope", "conversations.setTopic": "not_in_channel"})
+	env := fireTestEnv(t)
+
+	fire := &slack.SlashCommand{Command: "/fire", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	require.Equal(t, "U1", fake.called("conversations.invite")[0].Get("users"))
+	require.Len(t, fake.called("chat.postMessage"), 1)
+	require.Equal(t, ":fire: *FIRE-1 New Fire (SEV2)* opened by <@U1>\nJoin the fight in <#CFIRE>", (<-posted).Text)
+}
+
+func TestFiredownClosesTheChannelsFire(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "sev1 checkout is down --announce", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	<-posted
+
+	again := *fire
+	again.ChannelID = "CFIRE"
+	response, err := findCommand("/fire").execute(env, &again)
+	require.Nil(t, err)
+	msg := decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.Equal(t, "*FIRE-1 checkout is down (SEV1)* is already burning in this channel, use `/firedown` when it is out", msg.Text)
+
+	down := &slack.SlashCommand{Command: "/firedown", ChannelID: "CFIRE", UserID: "U2"}
+	response, err = findCommand("/firedown").execute(env, down)
+	require.Nil(t, err)
+	msg = decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.True(t, strings.HasPrefix(msg.Text, ":white_check_mark: *FIRE-1 checkout is down (SEV1)* is out after less than a minute\n1. Ask"))
+	i, err := incidentStore.Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, incident.StatusResolved, i.Status)
+	resolved := fake.called("chat.postMessage")[2]
+	require.Equal(t, "C024FV14Z", resolved.Get("channel"))
+	require.Equal(t, "1603980505.000100", resolved.Get("thread_ts"))
+	require.Equal(t, ":white_check_mark: *FIRE-1 checkout is down (SEV1)* is out after less than a minute", resolved.Get("text"))
+	reaction := fake.called("reactions.add")[0]
+	require.Equal(t, "white_check_mark", reaction.Get("name"))
+	require.Equal(t, "C024FV14Z", reaction.Get("channel"))
+	require.Equal(t, "1603980505.000100", reaction.Get("timestamp"))
+
+	response, err = findCommand("/firedown").execute(env, down)
+	require.Nil(t, err)
+	msg = decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.Equal(t, "There is no fire burning in this channel, use `/fire` to open one", msg.Text)
+}
+
+func TestFireUpdatePostsInTheAnnouncementThread(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+
+	update := &slack.SlashCommand{Command: "/fire", Text: "update the deploy has been rolled back", ChannelID: "CFIRE", UserID: "U2"}
+	response, err := findCommand("/fire").execute(env, update)
+	require.Nil(t, err)
+	require.Equal(t, "There is no fire burning in this channel, use `/fire` to open one", decodeMsg(t, response).Text)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "checkout is down --announce", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err = findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	<-posted
+
+	response, err = findCommand("/fire").execute(env, update)
+	require.Nil(t, err)
+	msg := decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.Equal(t, ":loudspeaker: <@U2> posted an update to <#C024FV14Z>: the deploy has been rolled back", msg.Text)
+	reply := fake.called("chat.postMessage")[2]
+	require.Equal(t, "C024FV14Z", reply.Get("channel"))
+	require.Equal(t, "1603980505.000100", reply.Get("thread_ts"))
+	require.Equal(t, "<@U2>: the deploy has been rolled back", reply.Get("text"))
+	i, err := incidentStore.OpenIn("CFIRE")
+	require.Nil(t, err)
+	require.Equal(t, "posted an update: the deploy has been rolled back", i.Timeline[len(i.Timeline)-1].Text)
+
+	update.Text = "Update"
+	response, err = findCommand("/fire").execute(env, update)
+	require.Nil(t, err)
+	require.Equal(t, "Tell me what to post, e.g. `/fire update the deploy has been rolled back`", decodeMsg(t, response).Text)
+}
+
+func TestFireUpdateWithoutAnAnnouncement(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, map[string]string{"chat.postMessage": "not_in_channel"})
+	env := fireTestEnv(t)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "checkout is down", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	<-posted
+
+	update := &slack.SlashCommand{Command: "/fire", Text: "update rolled back", ChannelID: "CFIRE", UserID: "U2"}
+	response, err := findCommand("/fire").execute(env, update)
+	require.Nil(t, err)
+	require.Equal(t, "*FIRE-1 checkout is down (SEV2)* was never announced in <#C024FV14Z>, so there is no thread to post updates in. Use `/fire announce` if it is a real fire.", decodeMsg(t, response).Text)
+
+	down := &slack.SlashCommand{Command: "/firedown", ChannelID: "CFIRE", UserID: "U2"}
+	_, err = findCommand("/firedown").execute(env, down)
+	require.Nil(t, err)
+	require.Empty(t, fake.called("reactions.add"))
+}
+
+func TestParseFireUpdate(t *testing.T) {
+	text, ok := parseFireUpdate(" UPDATE  rolled back ")
+	require.True(t, ok)
+	require.Equal(t, "rolled back", text)
+	_, ok = parseFireUpdate("updates are failing")
+	require.False(t, ok)
+	_, ok = parseFireUpdate("")
+	require.False(t, ok)
+}
+
+func fireRoleClick(t *testing.T, responseURL string, actionID string, incidentID string, user string) *http.Request {
+	payload, err := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": responseURL,
+		"user":         map[string]string{"id": user},
+		"actions": []map[string]interface{}{
+			{"block_id": "fire_roles", "action_id": actionID, "type": "button", "value": incidentID},
+		},
+	})
+	require.Nil(t, err)
+	return deferred(signedRequest(url.Values{"payload": {string(payload)}}.Encode(), time.Now(), testSigningSecret))
+}
+
+func TestFireRoleButtons(t *testing.T) {
+	setTestEnv(t)
+	posted := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&msg))
+		posted <- msg
+	}))
+	defer server.Close()
+	incidentStore = incident.NewFileStore(filepath.Join(t.TempDir(), "incidents.json"))
+	defer func() { incidentStore = nil }()
+	now := time.Unix(1603980505, 0)
+	i, err := incident.Open(incidentStore, "checkout is down", "CFIRE", "U1", 1, now)
+	require.Nil(t, err)
+	i.Leader = "U1"
+	require.Nil(t, incidentStore.Save(i))
+
+	roles := func(msg map[string]interface{}) string {
+		blocks := msg["blocks"].([]interface{})
+		return blocks[1].(map[string]interface{})["text"].(map[string]interface{})["text"].(string)
+	}
+
+	w := httptest.NewRecorder()
+	Interactive(w, fireRoleClick(t, server.URL, "fire_doc", "FIRE-1", "U2"))
+	require.Equal(t, http.StatusOK, w.Code)
+	msg := <-posted
+	require.Equal(t, true, msg["replace_original"])
+	require.Equal(t, "in_channel", msg["response_type"])
+	require.Equal(t, "*Leader:* <@U1>\n*Doc maintainer:* <@U2>\n*Announcer:* nobody yet", roles(msg))
+	require.Len(t, msg["blocks"], 3)
+
+	Interactive(httptest.NewRecorder(), fireRoleClick(t, server.URL, "fire_lead", "FIRE-1", "U3"))
+	msg = <-posted
+	require.Equal(t, "*Leader:* <@U3>\n*Doc maintainer:* <@U2>\n*Announcer:* nobody yet", roles(msg))
+	i, err = incidentStore.Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, "U3", i.Leader)
+	require.Equal(t, "U2", i.DocMaintainer)
+	require.Equal(t, "took the role of leader", i.Timeline[len(i.Timeline)-1].Text)
+
+	_, err = incident.Close(incidentStore, "CFIRE", "U3", now)
+	require.Nil(t, err)
+	Interactive(httptest.NewRecorder(), fireRoleClick(t, server.URL, "fire_announce", "FIRE-1", "U4"))
+	msg = <-posted
+	require.Equal(t, "*Leader:* <@U3>\n*Doc maintainer:* <@U2>\n*Announcer:* nobody yet", roles(msg))
+	require.Len(t, msg["blocks"], 2)
+}
+
+func TestFireRoleButtonForAMissingFire(t *testing.T) {
+	setTestEnv(t)
+	posted := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&msg))
+		posted <- msg
+	}))
+	defer server.Close()
+	incidentStore = incident.NewFileStore(filepath.Join(t.TempDir(), "incidents.json"))
+	defer func() { incidentStore = nil }()
+
+	Interactive(httptest.NewRecorder(), fireRoleClick(t, server.URL, "fire_lead", "FIRE-9", "U2"))
+	msg := <-posted
+	require.Equal(t, false, msg["replace_original"])
+	require.Equal(t, "ephemeral", msg["response_type"])
+}
+
+func TestFireChannelName(t *testing.T) {
+	now := time.Unix(1603980505, 0)
+	require.Equal(t, "fire-checkout-is-down-2020-10-29-14-08", fireChannelName("Checkout is down!", now))
+	require.Equal(t, "fire-2020-10-29-14-08", fireChannelName("!!!", now))
+	long := fireChannelName(strings.Repeat("search is slow ", 10), now)
+	require.Len(t, long, 80)
+	require.True(t, strings.HasPrefix(long, "fire-search-is-slow-search-is-slow-"))
+	require.True(t, strings.HasSuffix(long, "-2020-10-29-14-08"))
+}
+
+func TestFireIsAcknowledgedFirstAndPostsItsResultOnce(t *testing.T) {
+	posted := make(chan *slack.Msg, 2)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+	require.True(t, findCommand("/fire").Async)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "checkout is down", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	findCommand("/fire").respond(httptest.NewRecorder(), deferredRequest(), env, fire)
+	require.Len(t, posted, 1)
+	require.True(t, strings.HasSuffix((<-posted).Text, "Join the fight in <#CFIRE>"))
+}
+
+func TestFireDownIsAcknowledgedFirstAndPostsItsResultOnce(t *testing.T) {
+	posted := make(chan *slack.Msg, 2)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+	require.True(t, findCommand("/firedown").Async)
+	_, err := incident.Open(incidentStore, "checkout is down", "CFIRE", "U1", 1, time.Now().Add(-time.Hour))
+	require.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	down := &slack.SlashCommand{Command: "/firedown", ChannelID: "CFIRE", UserID: "U2", ResponseURL: server.URL}
+	findCommand("/firedown").respond(w, deferredRequest(), env, down)
+	require.Empty(t, w.Body.String())
+	require.Len(t, posted, 1)
+	msg := <-posted
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.True(t, strings.HasPrefix(msg.Text, ":white_check_mark: *FIRE-1 checkout is down (SEV1)* is out after 1h"), msg.Text)
+	_, err = incidentStore.OpenIn("CFIRE")
+	require.Equal(t, incident.ErrNotFound, err)
+}
+
+func TestFireTestIsADryRun(t *testing.T) {
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+	env.GdriveFireTemplateID = "template"
+
+	fire := &slack.SlashCommand{Command: "/firetest", Text: "sev1 checkout is down", ChannelID: "C1", UserID: "U1"}
+	response, err := findCommand("/firetest").execute(env, fire)
+	require.Nil(t, err)
+	msg := decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.True(t, strings.HasPrefix(msg.Text, "Dry run, nothing was created. `/fire` would:\n"+
+		"• create #fire-checkout-is-down-"), msg.Text)
+	require.Contains(t, msg.Text, " and invite <!subteam^S01DXD4HKCH> and you\n• copy the fire doc template into the fire doc folder\n"+
+		"• not announce it, add `--announce` or use `/fire announce` later if it is a real fire\n• post this checklist there\n")
+	require.Contains(t, msg.Text, "\n\n:fire: *FIRE-? checkout is down (SEV1)* opened by <@U1>\n1. The <!subteam^S01DXD4HKCH> have been invited")
+
+	fire.Text = "sev1 checkout is down --announce"
+	response, err = findCommand("/firetest").execute(env, fire)
+	require.Nil(t, err)
+	require.Contains(t, decodeMsg(t, response).Text, "\n• announce it in <#C024FV14Z>\n")
+
+	update := &slack.SlashCommand{Command: "/firetest", Text: "update rolled back", ChannelID: "C1", UserID: "U1"}
+	response, err = findCommand("/firetest").execute(env, update)
+	require.Nil(t, err)
+	require.Equal(t, "Dry run, nothing was posted. `/fire` would post this update in the announcement thread of this channel's fire: rolled back", decodeMsg(t, response).Text)
+
+	require.Empty(t, fake.calls)
+	_, err = incidentStore.OpenIn("C1")
+	require.Equal(t, incident.ErrNotFound, err)
+}
+
+func TestFireIsOnlyAnnouncedWhenAsked(t *testing.T) {
+	posted := make(chan *slack.Msg, 1)
+	server := responseURLServer(t, posted)
+	defer server.Close()
+	fake := newFakeSlack(t, nil)
+	env := fireTestEnv(t)
+
+	fire := &slack.SlashCommand{Command: "/fire", Text: "sev3 search is slow", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+	_, err := findCommand("/fire").execute(env, fire)
+	require.Nil(t, err)
+	<-posted
+	posts := fake.called("chat.postMessage")
+	require.Len(t, posts, 1)
+	require.Equal(t, "CFIRE", posts[0].Get("channel"))
+	require.Contains(t, posts[0].Get("text"), "\n5. If a real fire - announcer announces it in <#C024FV14Z> with `/fire announce`\n")
+
+	announce := &slack.SlashCommand{Command: "/fire", Text: " Announce ", ChannelID: "CFIRE", UserID: "U2"}
+	response, err := findCommand("/fire").execute(env, announce)
+	require.Nil(t, err)
+	msg := decodeMsg(t, response)
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.Equal(t, ":loudspeaker: <@U2> announced *FIRE-1 search is slow (SEV3)* in <#C024FV14Z>, post updates in its thread with `/fire update <text>`", msg.Text)
+	posts = fake.called("chat.postMessage")
+	require.Len(t, posts, 2)
+	require.Equal(t, "C024FV14Z", posts[1].Get("channel"))
+	i, err := incidentStore.OpenIn("CFIRE")
+	require.Nil(t, err)
+	require.Equal(t, "1603980505.000100", i.Announcement)
+	require.Equal(t, "announced the fire", i.Timeline[len(i.Timeline)-1].Text)
+
+	response, err = findCommand("/fire").execute(env, announce)
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(decodeMsg(t, response).Text, "*FIRE-1 search is slow (SEV3)* has already been announced"))
+	require.Len(t, fake.called("chat.postMessage"), 2)
+}
+
+func TestFireStoreIsSharedByTheCommandAndInteractiveFunctions(t *testing.T) {
+	setTestEnv(t)
+	redis := fakeRedis(t)
+	os.Setenv("FIRE_STORE_URL", redis.URL)
+	os.Setenv("FIRE_STORE_TOKEN", "redis-token")
+	t.Cleanup(func() {
+		os.Unsetenv("FIRE_STORE_URL")
+		os.Unsetenv("FIRE_STORE_TOKEN")
+		incidentStore = nil
+	})
+	fake := newFakeSlack(t, nil)
+	posted := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&msg))
+		posted <- msg
+	}))
+	defer server.Close()
+
+	// each vercel function is its own process, so neither sees the other's incidentStore
+	incidentStore = nil
+	body := url.Values{"command": {"/fire"}, "text": {"sev1 checkout is down"}, "channel_id": {"C1"}, "user_id": {"U1"}, "response_url": {server.URL}}
+	w := httptest.NewRecorder()
+	Handler(w, deferred(signedRequest(body.Encode(), time.Now(), testSigningSecret)))
+	require.Equal(t, http.StatusOK, w.Code)
+	msg := <-posted
+	require.Contains(t, msg["text"], "FIRE-1 checkout is down (SEV1)")
+	require.Len(t, fake.called("conversations.create"), 1)
+
+	incidentStore = nil
+	Interactive(httptest.NewRecorder(), fireRoleClick(t, server.URL, "fire_doc", "FIRE-1", "U2"))
+	msg = <-posted
+	require.Equal(t, true, msg["replace_original"])
+	require.Equal(t, "in_channel", msg["response_type"])
+
+	i, err := incident.NewRedisStore(redis.URL, "redis-token").Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, "CFIRE", i.Channel)
+	require.Equal(t, "U1", i.Leader)
+	require.Equal(t, "U2", i.DocMaintainer)
+}
+
+func TestFireIsFoughtWithoutTheIncidentStore(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"ERR redis is down"}`))
+	}))
+	defer failing.Close()
+	stores := map[string]incident.Store{
+		"unconfigured": nil,
+		"failing":      incident.NewRedisStore(failing.URL, "redis-token"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			posted := make(chan *slack.Msg, 1)
+			server := responseURLServer(t, posted)
+			defer server.Close()
+			fake := newFakeSlack(t, nil)
+			env := fireTestEnv(t)
+			incidentStore = store
+
+			fire := &slack.SlashCommand{Command: "/fire", Text: "sev1 checkout is down", ChannelID: "C1", UserID: "U1", ResponseURL: server.URL}
+			response, err := findCommand("/fire").execute(env, fire)
+			require.Nil(t, err)
+			require.Nil(t, response)
+			require.Equal(t, ":fire: *checkout is down (SEV1)* opened by <@U1>\nJoin the fight in <#CFIRE>", (<-posted).Text)
+
+			require.Len(t, fake.called("conversations.create"), 1)
+			posts := fake.called("chat.postMessage")
+			require.Len(t, posts, 1)
+			require.Equal(t, "CFIRE", posts[0].Get("channel"))
+			text := posts[0].Get("text")
+			require.True(t, strings.HasPrefix(text, ":warning: I couldn't save this fire, so there are no role buttons and `/fire update` and `/firedown` won't know about it.\n"+
+				":fire: *checkout is down (SEV1)* opened by <@U1>\n"), text)
+			require.Contains(t, text, "\n2. Agree who is fire leader, document maintainer and announcements updater\n")
+			require.NotContains(t, posts[0].Get("blocks"), fireRolesBlockID)
+
+			update := &slack.SlashCommand{Command: "/fire", Text: "update rolled back", ChannelID: "CFIRE", UserID: "U1"}
+			_, err = findCommand("/fire").execute(env, update)
+			if store == nil {
+				require.EqualError(t, err, "missing required Incident store credentials")
+			} else {
+				require.NotNil(t, err)
+			}
+		})
+	}
+}
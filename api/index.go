@@ -2,12 +2,16 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"net/http"
+	"net/http/httptrace"
 	"reflect"
 	"strings"
 	"time"
@@ -16,33 +20,91 @@ import (
 	"github.com/kelseyhightower/envconfig"
 	"github.com/nlopes/slack"
 
+	"github.com/searchspring/nebo/gdrive"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/incident"
 	"github.com/searchspring/nebo/nextopia"
+	"github.com/searchspring/nebo/productboard"
 	"github.com/searchspring/nebo/salesforce"
+	"github.com/searchspring/nebo/slackauth"
 )
 
 type envVars struct {
-	DevMode                string `split_words:"true" required:"true"`
-	SlackVerificationToken string `split_words:"true" required:"true"`
-	SlackOauthToken        string `split_words:"true" required:"true"`
-	SfURL                  string `split_words:"true" required:"true"`
-	SfUser                 string `split_words:"true" required:"true"`
-	SfPassword             string `split_words:"true" required:"true"`
-	SfToken                string `split_words:"true" required:"true"`
-	NxUser                 string `split_words:"true" required:"true"`
-	NxPassword             string `split_words:"true" required:"true"`
-	GdriveFireDocFolderID  string `split_words:"true" required:"true"`
+	DevMode                string        `split_words:"true" required:"true"`
+	SlackSigningSecret     string        `split_words:"true" required:"true"`
+	SlackVerificationToken string        `split_words:"true"`
+	SlackAllowLegacyToken  bool          `split_words:"true"`
+	SlackOauthToken        string        `split_words:"true" required:"true"`
+	SfURL                  string        `split_words:"true" required:"true"`
+	SfUser                 string        `split_words:"true" required:"true"`
+	SfPassword             string        `split_words:"true" required:"true"`
+	SfToken                string        `split_words:"true" required:"true"`
+	SfContractStartField   string        `split_words:"true"`
+	SfRenewalField         string        `split_words:"true"`
+	NxURL                  string        `split_words:"true"`
+	NxUser                 string        `split_words:"true" required:"true"`
+	NxPassword             string        `split_words:"true" required:"true"`
+	NxCacheTTL             time.Duration `split_words:"true" default:"1h"`
+	NxCacheFile            string        `split_words:"true"`
+	NeboAdmins             []string      `split_words:"true"`
+	GdriveFireDocFolderID  string        `split_words:"true" required:"true"`
+	GdriveFireTemplateID   string        `split_words:"true"`
+	GdriveServiceAccount   string        `split_words:"true"`
+	FireStoreURL           string        `split_words:"true"`
+	FireStoreToken         string        `split_words:"true"`
+	ProductboardToken      string        `split_words:"true"`
 }
 
 var salesForceDAO salesforce.DAO = nil
 var nextopiaDAO nextopia.DAO = nil
+var productboardDAO productboard.DAO = nil
+var incidentStore incident.Store = nil
+var gdriveDAO gdrive.DAO = nil
+
+// slackClient posts to slack, both through the api and to response urls
+var slackClient = httpclient.New("Slack", 5*time.Second)
+
+// deferredHeader marks a slack request nebo has forwarded to itself so the work is done after the
+// original request has been acknowledged
+const deferredHeader = "X-Nebo-Deferred"
+
+// handOffTimeout is how long to wait for a forwarded request to be sent, well inside the three
+// seconds slack waits for an acknowledgement
+const handOffTimeout = 1500 * time.Millisecond
+
+// handOffClient forwards requests without retrying, a second attempt would do the work twice
+var handOffClient = &http.Client{}
 
 // Handler - check routing and call correct methods
 func Handler(w http.ResponseWriter, r *http.Request) {
+	env, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	s, err := slack.SlashCommandParse(r)
+	if err != nil {
+		sendInternalServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-type", "application/json")
+	c := findCommand(s.Command)
+	if c == nil {
+		sendInternalServerError(w, errors.New("unknown slash command "+s.Command))
+		return
+	}
+	c.respond(w, r, env, &s)
+}
+
+// authenticate loads the env vars and checks the request came from slack, writing an error
+// response if either fails
+func authenticate(w http.ResponseWriter, r *http.Request) (*envVars, bool) {
 	var env envVars
 	err := envconfig.Process("", &env)
 	if err != nil {
 		sendInternalServerError(w, err)
-		return
+		return nil, false
 	}
 
 	blanks := findBlankEnvVars(env)
@@ -50,188 +112,137 @@ func Handler(w http.ResponseWriter, r *http.Request) {
 		err := fmt.Errorf("the following env vars are blank: %s", strings.Join(blanks, ", "))
 		if env.DevMode != "development" {
 			sendInternalServerError(w, err)
-			return
+			return nil, false
 		}
 		log.Printf(err.Error())
 	}
 
-	s, err := slack.SlashCommandParse(r)
-	if err != nil {
-		sendInternalServerError(w, err)
-		return
+	legacyToken := ""
+	if env.SlackAllowLegacyToken {
+		legacyToken = env.SlackVerificationToken
 	}
-
-	if !s.ValidateToken(env.SlackVerificationToken) {
-		err := errors.New("slack verification failed")
-		log.Println(err.Error())
-		http.Error(w, err.Error(), http.StatusUnauthorized)
-		return
+	verifier := slackauth.NewVerifier(env.SlackSigningSecret, legacyToken)
+	if err := verifier.Verify(r); err != nil {
+		log.Println("slack verification failed: " + err.Error())
+		http.Error(w, "slack verification failed", http.StatusUnauthorized)
+		return nil, false
 	}
-
-	nextopiaDAO = nextopia.NewDAO(env.NxUser, env.NxPassword)
-	salesForceDAO = salesforce.NewDAO(env.SfURL, env.SfUser, env.SfPassword, env.SfToken)
-
-	w.Header().Set("Content-type", "application/json")
-	switch s.Command {
-	case "/rep", "/alpha-nebo", "/nebo":
-		if strings.TrimSpace(s.Text) == "help" || strings.TrimSpace(s.Text) == "" {
-			writeHelpNebo(w)
-			return
-		}
-		if salesForceDAO == nil {
-			sendInternalServerError(w, errors.New("missing required Salesforce credentials"))
-			return
-		}
-		responseJSON, err := salesForceDAO.Query(s.Text)
-		if err != nil {
-			sendInternalServerError(w, err)
-			return
-		}
-		w.Write(responseJSON)
-		return
-
-	case "/fire", "/firetest":
-		if strings.TrimSpace(s.Text) == "help" {
-			writeHelpFire(w)
-			return
-		}
-		fireResponse(env.GdriveFireDocFolderID, s.ResponseURL)
-		return
-
-	case "/firedown":
-		w.Write(fireDownResponse())
-		return
-
-	case "/neboidnx", "/neboid":
-		if strings.TrimSpace(s.Text) == "help" || strings.TrimSpace(s.Text) == "" {
-			writeHelpNeboid(w)
-			return
-		}
-		if nextopiaDAO == nil {
-			sendInternalServerError(w, errors.New("missing required Nextopia credentials"))
-			return
-		}
-		responseJSON, err := nextopiaDAO.Query(s.Text)
-		if err != nil {
-			sendInternalServerError(w, err)
-			return
-		}
-		w.Write(responseJSON)
-		return
-
-	case "/neboidss":
-		if strings.TrimSpace(s.Text) == "help" || strings.TrimSpace(s.Text) == "" {
-			writeHelpNeboid(w)
-			return
-		}
-		if salesForceDAO == nil {
-			sendInternalServerError(w, errors.New("missing required Salesforce credentials"))
-			return
-		}
-		responseJSON, err := salesForceDAO.IDQuery(s.Text)
-		if err != nil {
-			sendInternalServerError(w, err)
-			return
-		}
-		w.Write(responseJSON)
-		return
-
-	case "/feature":
-		if strings.TrimSpace(s.Text) == "help" || strings.TrimSpace(s.Text) == "" {
-			writeHelpFeature(w)
-			return
-		}
-		sendSlackMessage(env.SlackOauthToken, s.Text, s.UserID)
-		responseJSON := featureResponse(s.Text)
-		w.Write(responseJSON)
-		return
-
-	case "/meet":
-		if strings.TrimSpace(s.Text) == "help" {
-			writeHelpMeet(w)
-			return
-		}
-		responseJSON := meetResponse(s.Text)
-		w.Write(responseJSON)
-		return
-
-	case "/meettest":
-		if strings.TrimSpace(s.Text) == "help" {
-			writeHelpMeet(w)
-			return
-		}
-		responseJSON := meetResponse(s.Text)
-		w.Write(responseJSON)
-		return
-
-	default:
-		sendInternalServerError(w, errors.New("unknown slash command "+s.Command))
-		return
+	if err := keepBody(r); err != nil {
+		sendInternalServerError(w, err)
+		return nil, false
 	}
+	return &env, true
 }
 
-func writeHelpFeature(w http.ResponseWriter) {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "Feature usage:\n`/feature description of feature required` - submits a feature to the product team\n`/feature help` - this message",
+// keepBody lets the body be read again after it has been parsed, so the request can be handed off
+func keepBody(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
 	}
-	json, _ := json.Marshal(msg)
-	w.Write(json)
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+	r.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewBuffer(body)), nil
+	}
+	return nil
 }
 
-func writeHelpFire(w http.ResponseWriter) {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "Fire usage:\n`/fire` - generate a fire checklist to handle the fire",
-	}
-	json, _ := json.Marshal(msg)
-	w.Write(json)
+// isDeferred reports whether the request was forwarded by handOff and should do the work itself
+func isDeferred(r *http.Request) bool {
+	return r.Header.Get(deferredHeader) != ""
 }
 
-func writeHelpNebo(w http.ResponseWriter) {
-	platformsJoined := strings.ToLower(strings.Join(salesforce.Platforms, ", "))
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "Nebo usage:\n`/nebo shoes` - find all customers with shoe in the name\n`/nebo shopify` - show {" + platformsJoined + "} clients sorted by MRR\n`/nebo help` - this message",
+// handOff forwards the slack request, still signed by slack, to a second invocation so the work
+// carries on after this one returns. The serverless platform only sends a response once the handler
+// returns, so the acknowledgement cannot go out ahead of work done in the same invocation. handOff
+// returns as soon as the request has been sent, reporting whether it was.
+func handOff(r *http.Request) bool {
+	if r.GetBody == nil {
+		return false
 	}
-	json, _ := json.Marshal(msg)
-	w.Write(json)
-}
-func writeHelpNeboid(w http.ResponseWriter) {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "Neboid usage:\n`/neboid <id prefix>` - find all customers with an id that starts with this prefix\n`/neboid help` - this message",
+	body, err := r.GetBody()
+	if err != nil {
+		log.Println("handing off: " + err.Error())
+		return false
 	}
-	json, _ := json.Marshal(msg)
-	w.Write(json)
-}
-
-func writeHelpMeet(w http.ResponseWriter) {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "Meet usage:\n`/meet` - generate a random meet\n`/meet name` - generate a meet with a name\n`/meet help` - this message",
+	scheme := r.Header.Get("X-Forwarded-Proto")
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	sent := make(chan struct{}, 1)
+	trace := &httptrace.ClientTrace{
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			if info.Err == nil {
+				select {
+				case sent <- struct{}{}:
+				default:
+				}
+			}
+		},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handOffTimeout)
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), http.MethodPost,
+		scheme+"://"+r.Host+r.URL.RequestURI(), body)
+	if err != nil {
+		cancel()
+		log.Println("handing off: " + err.Error())
+		return false
+	}
+	req.Header = r.Header.Clone()
+	req.Header.Set(deferredHeader, "1")
+	done := make(chan error, 1)
+	go func() {
+		defer cancel()
+		res, err := handOffClient.Do(req)
+		if err == nil {
+			res.Body.Close()
+		}
+		done <- err
+	}()
+	select {
+	case <-sent:
+		return true
+	case err := <-done:
+		if err == nil {
+			return true
+		}
+		log.Println("handing off: " + err.Error())
+		return false
 	}
-	json, _ := json.Marshal(msg)
-	w.Write(json)
 }
 
-func sendSlackMessage(token string, text string, authorID string) {
-	api := slack.New(token)
+// sendSlackMessage posts the request to the product channel and returns a note that links back to it
+func sendSlackMessage(token string, text string, authorID string) *productboard.Note {
+	note := &productboard.Note{
+		Content: text,
+		Tags:    []string{"nebo"},
+	}
+	api := newSlackAPI(token)
+	user, err := api.GetUserInfo(authorID)
+	if err != nil {
+		log.Println("looking up feature requester: " + err.Error())
+	} else {
+		note.Requester = user.RealName
+		note.Email = user.Profile.Email
+	}
 	channelID, timestamp, err := api.PostMessage("G013YLWL3EX", slack.MsgOptionText("<@"+authorID+"> requests: "+text, false))
 	if err != nil {
-		fmt.Printf("%s\n", err)
-		return
+		log.Println("posting feature request: " + err.Error())
+		return note
 	}
-	fmt.Printf("Message successfully sent to channel %s at %s", channelID, timestamp)
-}
-
-func featureResponse(search string) []byte {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "feature request submitted, we'll be in touch!",
+	log.Printf("feature request posted to channel %s at %s\n", channelID, timestamp)
+	note.SourceID = timestamp
+	permalink, err := api.GetPermalink(&slack.PermalinkParameters{Channel: channelID, Ts: timestamp})
+	if err != nil {
+		log.Println("linking to feature request: " + err.Error())
+		return note
 	}
-	json, _ := json.Marshal(msg)
-	return json
+	note.SourceURL = permalink
+	return note
 }
 
 func meetResponse(search string) []byte {
@@ -253,11 +264,6 @@ func getMeetLink(search string) string {
 	return "g.co/meet/" + name
 }
 
-func fireResponse(folderID string, responseURL string) {
-	checklist := fireChecklist(folderID)
-	postSlackMessage(responseURL, slack.ResponseTypeInChannel, checklist)
-}
-
 func postSlackMessage(responseURL string, responseType string, text string) error {
 	msg := &slack.Msg{
 		ResponseType: responseType,
@@ -267,39 +273,20 @@ func postSlackMessage(responseURL string, responseType string, text string) erro
 	if err != nil {
 		return err
 	}
-	_, err = http.Post(responseURL, "application/json", bytes.NewBuffer(json))
-	return err
+	return postSlackResponse(responseURL, json)
 }
 
-func cleanFireTitle(title string) string {
-	title = strings.TrimSpace(title)
-	if title == "" {
-		title = "New Fire"
+// postSlackResponse sends an already encoded message to a slash command's response URL
+func postSlackResponse(responseURL string, responseJSON []byte) error {
+	res, err := slackClient.Post(responseURL, "application/json", bytes.NewBuffer(responseJSON))
+	if err != nil {
+		return err
 	}
-	return title
-}
-
-func fireChecklist(folderID string) string {
-	text := "1. Assemble the <!subteam^S01DXD4HKCH> in the <#C01DFMK1F4M> channel\n" +
-		"2. Designate fire leader, document maintainer, announcements updater\n" +
-		"3. Fire doc maintainer creates a new doc here: " + fmt.Sprintf("<https://drive.google.com/drive/folders/%s>", folderID) + "\n" +
-		"4. Post link to the fire doc\n" +
-		"5. If a real fire - announcer posts to the <#C024FV14Z> channel \"There is a fire and engineering is investigating, updates will be posted in a thread on this message\"\n" +
-		"6. Post a link to the fire document in the <#C024FV14Z> channel thread\n" +
-		"7. Fight! " + getMeetLink("fire-investigation-"+timestamp(time.Now())) + "\n\n\n" +
-		"8. Use `/firedown` when the fire is out\n"
-	return text
-}
-
-func fireDownResponse() []byte {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeInChannel,
-		Text: "1. Ask if there are any cleanup tasks to do\n" +
-			"2. Update the <#C024FV14Z>  channel\n" +
-			"3. If applicable, schedule a blameless post mortem\n",
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack response url returned %d", res.StatusCode)
 	}
-	json, _ := json.Marshal(msg)
-	return json
+	return nil
 }
 
 func timestamp(currentTime time.Time) string {
@@ -316,6 +303,9 @@ func findBlankEnvVars(env envVars) []string {
 	valueOfStruct := reflect.ValueOf(env)
 	typeOfStruct := valueOfStruct.Type()
 	for i := 0; i < valueOfStruct.NumField(); i++ {
+		if typeOfStruct.Field(i).Tag.Get("required") != "true" {
+			continue
+		}
 		if valueOfStruct.Field(i).Interface() == "" {
 			blanks = append(blanks, typeOfStruct.Field(i).Name)
 		}
@@ -1,9 +1,16 @@
 package api
 
 import (
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/searchspring/nebo/slackauth"
 	"github.com/stretchr/testify/require"
 )
 
@@ -15,8 +22,96 @@ func TestFindBlankEnvVars(t *testing.T) {
 	for _, b := range blanks {
 		require.NotEqual(t, "DevMode", b)
 	}
+	require.Contains(t, blanks, "SlackSigningSecret")
+	require.NotContains(t, blanks, "SlackVerificationToken")
+	require.NotContains(t, blanks, "SlackAllowLegacyToken")
+}
+
+func TestHandlerOnlyAcceptsTheLegacyTokenWhenAllowed(t *testing.T) {
+	setTestEnv(t)
+	os.Setenv("SLACK_VERIFICATION_TOKEN", "legacy")
+	defer os.Unsetenv("SLACK_VERIFICATION_TOKEN")
+	unsigned := func() *http.Request {
+		r := httptest.NewRequest("POST", "/", strings.NewReader("command=%2Fmeet&text=standup&user_id=U1&token=legacy"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return r
+	}
+
+	w := httptest.NewRecorder()
+	Handler(w, unsigned())
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+
+	os.Setenv("SLACK_ALLOW_LEGACY_TOKEN", "true")
+	defer os.Unsetenv("SLACK_ALLOW_LEGACY_TOKEN")
+	w = httptest.NewRecorder()
+	Handler(w, unsigned())
+	require.Equal(t, http.StatusOK, w.Code)
 }
 
 func TestTimestamp(t *testing.T) {
 	require.Equal(t, "2020-10-29-14-08", timestamp(time.Unix(1603980505, 0)))
 }
+
+const testSigningSecret = "test-signing-secret"
+
+func setTestEnv(t *testing.T) {
+	vars := map[string]string{
+		"DEV_MODE":                  "development",
+		"SLACK_SIGNING_SECRET":      testSigningSecret,
+		"SLACK_OAUTH_TOKEN":         "",
+		"SF_URL":                    "",
+		"SF_USER":                   "",
+		"SF_PASSWORD":               "",
+		"SF_TOKEN":                  "",
+		"NX_USER":                   "",
+		"NX_PASSWORD":               "",
+		"GDRIVE_FIRE_DOC_FOLDER_ID": "",
+	}
+	for k, v := range vars {
+		os.Setenv(k, v)
+	}
+	t.Cleanup(func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	})
+}
+
+func signedRequest(body string, ts time.Time, secret string) *http.Request {
+	stimestamp := strconv.FormatInt(ts.Unix(), 10)
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Request-Timestamp", stimestamp)
+	r.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(slackauth.Sign(secret, stimestamp, []byte(body))))
+	return r
+}
+
+// deferred marks the request as handed off, so the handler does the work rather than handing it off
+func deferred(r *http.Request) *http.Request {
+	r.Header.Set(deferredHeader, "1")
+	return r
+}
+
+func TestHandlerVerification(t *testing.T) {
+	setTestEnv(t)
+	body := "command=%2Fmeet&text=standup&user_id=U1"
+	tests := []struct {
+		name    string
+		request *http.Request
+		status  int
+	}{
+		{"signed", signedRequest(body, time.Now(), testSigningSecret), http.StatusOK},
+		{"forged", signedRequest(body, time.Now(), "not-the-secret"), http.StatusUnauthorized},
+		{"replayed", signedRequest(body, time.Now().Add(-10*time.Minute), testSigningSecret), http.StatusUnauthorized},
+		{"unsigned", httptest.NewRequest("POST", "/", strings.NewReader(body+"&token=legacy")), http.StatusUnauthorized},
+	}
+	os.Setenv("SLACK_VERIFICATION_TOKEN", "legacy")
+	defer os.Unsetenv("SLACK_VERIFICATION_TOKEN")
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			Handler(w, test.request)
+			require.Equal(t, test.status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,179 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nlopes/slack"
+
+	"github.com/searchspring/nebo/customer"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/nextopia"
+	"github.com/searchspring/nebo/paging"
+	"github.com/searchspring/nebo/render"
+	"github.com/searchspring/nebo/salesforce"
+)
+
+// interaction handles clicks on the buttons in a block, identified by the block ID or, for
+// elements that appear in many blocks, the action ID. Interactions that replace the message
+// redraw the message that was clicked on, otherwise the response is posted alongside it.
+type interaction struct {
+	BlockID  string
+	ActionID string
+	Replaces bool
+	Requires []*credential
+	Run      func(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error)
+}
+
+var interactions = []*interaction{
+	{
+		BlockID:  salesforce.PageBlockID,
+		Replaces: true,
+		Requires: []*credential{salesforceCredential},
+		Run: func(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error) {
+			page, err := paging.Parse(action.Value)
+			if err != nil {
+				return nil, err
+			}
+			return salesForceDAO.Query(page.Search, page.Offset)
+		},
+	},
+	{
+		BlockID:  nextopia.PageBlockID,
+		Replaces: true,
+		Requires: []*credential{nextopiaCredential},
+		Run: func(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error) {
+			page, err := paging.Parse(action.Value)
+			if err != nil {
+				return nil, err
+			}
+			return nextopiaDAO.Query(page.Search, page.Offset)
+		},
+	},
+	{
+		BlockID:  customer.PageBlockID,
+		Replaces: true,
+		Run: func(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error) {
+			page, err := paging.Parse(action.Value)
+			if err != nil {
+				return nil, err
+			}
+			return lookupCustomer(env, page.Search, page.Offset)
+		},
+	},
+	{
+		BlockID:  fireRolesBlockID,
+		Replaces: true,
+		Requires: []*credential{incidentsCredential},
+		Run: func(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error) {
+			return takeFireRole(env, callback.User.ID, action, time.Now())
+		},
+	},
+	{
+		ActionID: render.AccountActionID,
+		Run: func(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error) {
+			value := action.Value
+			if action.SelectedOption.Value != "" {
+				value = action.SelectedOption.Value
+			}
+			if !strings.HasPrefix(value, render.CopyIDPrefix) {
+				// links are opened by slack, there is nothing to post
+				return nil, nil
+			}
+			return json.Marshal(render.CopyIDMessage(strings.TrimPrefix(value, render.CopyIDPrefix)))
+		},
+	},
+}
+
+// Interactive - handle button clicks on messages nebo has posted
+func Interactive(w http.ResponseWriter, r *http.Request) {
+	env, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	callback := &slack.InteractionCallback{}
+	err := json.Unmarshal([]byte(r.FormValue("payload")), callback)
+	if err != nil {
+		sendInternalServerError(w, err)
+		return
+	}
+	if callback.Type != slack.InteractionTypeBlockActions || len(callback.ActionCallback.BlockActions) == 0 {
+		sendInternalServerError(w, errors.New("unsupported interaction "+string(callback.Type)))
+		return
+	}
+
+	action := callback.ActionCallback.BlockActions[0]
+	i := findInteraction(action)
+	if i == nil {
+		sendInternalServerError(w, errors.New("unknown interaction "+action.BlockID+" "+action.ActionID))
+		return
+	}
+	i.respond(w, r, env, callback, action)
+}
+
+// findInteraction returns the interaction registered for the block or action
+func findInteraction(action *slack.BlockAction) *interaction {
+	for _, i := range interactions {
+		if (i.BlockID != "" && i.BlockID == action.BlockID) || (i.ActionID != "" && i.ActionID == action.ActionID) {
+			return i
+		}
+	}
+	return nil
+}
+
+// respond acknowledges the click straight away and hands the work off to a second invocation, which
+// posts the result, replacing the original message if the interaction asks for it. If the work cannot
+// be handed off it is done here.
+func (i *interaction) respond(w http.ResponseWriter, r *http.Request, env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) {
+	w.WriteHeader(http.StatusOK)
+	if !isDeferred(r) && handOff(r) {
+		return
+	}
+	responseJSON, err := i.execute(env, callback, action)
+	if err == nil && responseJSON == nil {
+		return
+	}
+	if err == nil && i.Replaces {
+		responseJSON, err = replaceOriginal(responseJSON)
+	}
+	if err != nil {
+		log.Println(err.Error())
+		responseJSON = interactionFailure(err)
+	}
+	err = postSlackResponse(callback.ResponseURL, responseJSON)
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+func (i *interaction) execute(env *envVars, callback *slack.InteractionCallback, action *slack.BlockAction) ([]byte, error) {
+	for _, cred := range i.Requires {
+		if !cred.Load(env) {
+			return nil, cred.missing()
+		}
+	}
+	return i.Run(env, callback, action)
+}
+
+// replaceOriginal marks a message so that it updates the message that was clicked on
+func replaceOriginal(responseJSON []byte) ([]byte, error) {
+	msg := map[string]interface{}{}
+	err := json.Unmarshal(responseJSON, &msg)
+	if err != nil {
+		return nil, err
+	}
+	msg["replace_original"] = true
+	return json.Marshal(msg)
+}
+
+func interactionFailure(err error) []byte {
+	if name, ok := httpclient.IsDown(err); ok {
+		return downMessage(name)
+	}
+	return ephemeral("Sorry, I couldn't do that just now. Please try again in a minute.")
+}
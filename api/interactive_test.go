@@ -0,0 +1,88 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func interactionBody(responseURL string, blockID string, value string) string {
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": responseURL,
+		"actions": []map[string]interface{}{
+			{"block_id": blockID, "action_id": "next", "type": "button", "value": value},
+		},
+	})
+	return url.Values{"payload": {string(payload)}}.Encode()
+}
+
+func TestInteractivePagesAndReplacesOriginal(t *testing.T) {
+	setTestEnv(t)
+	posted := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&msg))
+		posted <- msg
+	}))
+	defer server.Close()
+	dao := &fakeSalesforceDAO{response: []byte(`{"response_type":"in_channel","text":"Reps for search: shoes"}`)}
+	salesForceDAO = dao
+	defer func() { salesForceDAO = nil }()
+
+	w := httptest.NewRecorder()
+	Interactive(w, deferred(signedRequest(interactionBody(server.URL, "salesforce_page", `{"q":"shoes","o":20}`), time.Now(), testSigningSecret)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	msg := <-posted
+	require.Equal(t, true, msg["replace_original"])
+	require.Equal(t, "Reps for search: shoes", msg["text"])
+	require.Equal(t, "shoes", dao.search)
+	require.Equal(t, 20, dao.offset)
+}
+
+func TestInteractiveRejectsForgedRequests(t *testing.T) {
+	setTestEnv(t)
+	w := httptest.NewRecorder()
+	Interactive(w, signedRequest(interactionBody("http://127.0.0.1:0", "salesforce_page", `{"q":"shoes","o":20}`), time.Now(), "not-the-secret"))
+	require.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestInteractiveUnknownBlock(t *testing.T) {
+	setTestEnv(t)
+	w := httptest.NewRecorder()
+	Interactive(w, signedRequest(interactionBody("http://127.0.0.1:0", "mystery", ""), time.Now(), testSigningSecret))
+	require.Equal(t, http.StatusInternalServerError, w.Code)
+}
+
+func TestInteractiveCopyID(t *testing.T) {
+	setTestEnv(t)
+	posted := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		msg := map[string]interface{}{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&msg))
+		posted <- msg
+	}))
+	defer server.Close()
+	payload, _ := json.Marshal(map[string]interface{}{
+		"type":         "block_actions",
+		"response_url": server.URL,
+		"actions": []map[string]interface{}{
+			{"block_id": "abc", "action_id": "account_actions", "type": "overflow", "selected_option": map[string]interface{}{"value": "copy_id:m6umjp"}},
+		},
+	})
+
+	w := httptest.NewRecorder()
+	Interactive(w, deferred(signedRequest(url.Values{"payload": {string(payload)}}.Encode(), time.Now(), testSigningSecret)))
+
+	require.Equal(t, http.StatusOK, w.Code)
+	msg := <-posted
+	require.Equal(t, false, msg["replace_original"])
+	require.Equal(t, "ephemeral", msg["response_type"])
+	require.Equal(t, "`m6umjp`", msg["text"])
+}
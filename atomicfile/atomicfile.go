@@ -0,0 +1,29 @@
+// Package atomicfile writes files so that a concurrent reader sees either the old contents or the new
+// ones, never half of them.
+package atomicfile
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Write replaces the file with the body in one step, by writing a temporary file beside it and
+// renaming that over it
+func Write(file string, body []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,27 @@
+package atomicfile
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteReplacesTheFile(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "table.json")
+	require.Nil(t, Write(file, []byte("old")))
+	require.Nil(t, Write(file, []byte("new")))
+	body, err := ioutil.ReadFile(file)
+	require.Nil(t, err)
+	require.Equal(t, "new", string(body))
+
+	entries, err := ioutil.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+}
+
+func TestWriteToAMissingDirectory(t *testing.T) {
+	require.NotNil(t, Write(filepath.Join(t.TempDir(), "missing", "table.json"), []byte("new")))
+}
@@ -0,0 +1,239 @@
+// Package customer looks a customer up in every system nebo knows about and shows what each one
+// says about them side by side.
+package customer
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/nextopia"
+	"github.com/searchspring/nebo/paging"
+	"github.com/searchspring/nebo/render"
+	"github.com/searchspring/nebo/salesforce"
+)
+
+// PageBlockID identifies the paging buttons on /customer results
+const PageBlockID = "customer_page"
+
+// Customer is everything found about one domain
+type Customer struct {
+	Domain     string
+	Salesforce []*salesforce.Account
+	Nextopia   []*nextopia.Account
+}
+
+// errSignIn stands in for the error of a backend nebo could not sign in to
+var errSignIn = errors.New("couldn't sign in")
+
+// Lookup searches salesforce and nextopia at the same time and shows a page of customers, starting
+// at offset. Nextopia only has names and websites, so it is searched without the salesforce filters,
+// and not at all if the search is nothing but filters. If one of them fails the customers from the
+// other are shown with a note saying so; an error is only returned if both fail. A nil DAO is a
+// backend nebo could not sign in to, and counts as failing.
+func Lookup(sf salesforce.DAO, nx nextopia.DAO, search string, offset int) ([]byte, error) {
+	search = strings.TrimSpace(search)
+	var sfAccounts []*salesforce.Account
+	var nxAccounts []*nextopia.Account
+	var sfErr, nxErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if sf == nil {
+			sfErr = errSignIn
+			return
+		}
+		sfAccounts, sfErr = sf.Accounts(search)
+	}()
+	go func() {
+		defer wg.Done()
+		if nx == nil {
+			nxErr = errSignIn
+			return
+		}
+		if text := salesforce.SearchText(search); text != "" {
+			nxAccounts, nxErr = nx.Accounts(text)
+		}
+	}()
+	wg.Wait()
+	if sfErr != nil && nxErr != nil {
+		return nil, sfErr
+	}
+
+	notes := []string{}
+	if sfErr != nil {
+		notes = append(notes, failureNote("Salesforce", "Nextopia", sfErr))
+	}
+	if nxErr != nil {
+		notes = append(notes, failureNote("Nextopia", "Salesforce", nxErr))
+	}
+	customers := Merge(sfAccounts, nxAccounts)
+	return json.Marshal(message(search, offset, customers, notes))
+}
+
+// failureNote says which backend failed and why, as far as the user needs to know
+func failureNote(failed string, other string, err error) string {
+	reason := failed + " didn't answer"
+	if err == errSignIn {
+		reason = "I couldn't sign in to " + failed
+	} else if _, ok := httpclient.IsDown(err); ok {
+		reason = failed + " is down"
+	} else if salesforce.IsInvalidSearch(err) {
+		reason = failed + " couldn't " + strings.TrimPrefix(err.Error(), "couldn't ")
+	}
+	return ":warning: " + reason + ", showing " + other + " results only."
+}
+
+// Merge groups the accounts by domain. Customers are in salesforce's order, followed by customers
+// only nextopia knows about in nextopia's order.
+func Merge(sfAccounts []*salesforce.Account, nxAccounts []*nextopia.Account) []*Customer {
+	customers := []*Customer{}
+	byDomain := map[string]*Customer{}
+	find := func(key string, domain string) *Customer {
+		if c, ok := byDomain[key]; ok {
+			return c
+		}
+		c := &Customer{Domain: domain}
+		byDomain[key] = c
+		customers = append(customers, c)
+		return c
+	}
+	for _, account := range sfAccounts {
+		d := Domain(account.Website)
+		key := d
+		if key == "" {
+			key = "salesforce:" + account.ID + ":" + account.Name
+		}
+		c := find(key, d)
+		c.Salesforce = append(c.Salesforce, account)
+	}
+	for _, account := range nxAccounts {
+		d := Domain(account.URL)
+		key := d
+		if key == "" {
+			key = "nextopia:" + account.ID1
+		}
+		c := find(key, d)
+		c.Nextopia = append(c.Nextopia, account)
+	}
+	return customers
+}
+
+// Domain reduces a website to its lower case host, e.g. "https://www.Shoes.com/" is "shoes.com"
+func Domain(website string) string {
+	d := strings.ToLower(strings.TrimSpace(website))
+	if i := strings.Index(d, "://"); i >= 0 {
+		d = d[i+3:]
+	}
+	if i := strings.IndexAny(d, "/?#"); i >= 0 {
+		d = d[:i]
+	}
+	return strings.TrimPrefix(d, "www.")
+}
+
+func message(search string, offset int, customers []*Customer, notes []string) *slack.Msg {
+	text := "Customers matching: " + search
+	if len(customers) == 0 {
+		text = "No customers match: " + search
+	}
+	if len(notes) > 0 {
+		text += "\n" + strings.Join(notes, "\n")
+	}
+	start, end := paging.Bounds(offset, len(customers))
+	cards := []*render.Account{}
+	for _, c := range customers[start:end] {
+		cards = append(cards, card(c))
+	}
+	footer := []slack.Block{}
+	if len(customers) > 0 {
+		footer = paging.Blocks(PageBlockID, search, start, len(customers))
+	}
+	return render.Message(text, cards, footer)
+}
+
+// card shows the customer with the tracking codes and nextopia IDs that belong to it
+func card(c *Customer) *render.Account {
+	account := &render.Account{Title: c.Domain}
+	trackingCodes := []string{}
+	nextopiaIDs := []string{}
+	context := []string{}
+	for _, sf := range c.Salesforce {
+		if sf.TrackingCode != "" {
+			trackingCodes = append(trackingCodes, sf.TrackingCode)
+		}
+		if sf.Name != "" {
+			context = append(context, sf.Name)
+		}
+	}
+	for _, nx := range c.Nextopia {
+		nextopiaIDs = append(nextopiaIDs, nx.ID1)
+	}
+	account.Fields = []render.Field{
+		{Label: "Tracking code", Value: listOrNone(trackingCodes)},
+		{Label: "Nextopia IDs", Value: listOrNone(nextopiaIDs)},
+	}
+
+	switch {
+	case len(c.Salesforce) > 0:
+		sf := c.Salesforce[0]
+		account.Status = sf.Active
+		account.Fields = append(account.Fields,
+			render.Field{Label: "Rep", Value: sf.Manager},
+			render.Field{Label: "MRR", Value: salesforce.FormatMoney(sf.MRR)},
+			render.Field{Label: "Platform", Value: sf.Platform},
+		)
+		if sf.Link != "" {
+			account.Link = sf.Link
+			account.LinkText = "Open in Salesforce"
+		}
+	case len(c.Nextopia) > 0:
+		nx := c.Nextopia[0]
+		account.Status = "Inactive"
+		if nx.Active() {
+			account.Status = "Active"
+		}
+		account.Fields = append(account.Fields, render.Field{Label: "Nextopia plan", Value: nx.Plan})
+		if nx.URL != "" {
+			account.Link = "https://" + nx.URL
+			account.LinkText = "Open website"
+		}
+	}
+	if account.Title == "" && len(c.Nextopia) > 0 {
+		account.Title = c.Nextopia[0].Name
+	}
+	if account.Title == "" && len(context) > 0 {
+		account.Title = context[0]
+	}
+
+	context = append(context, systems(c))
+	account.Context = context
+	if len(trackingCodes) > 0 {
+		account.ID = trackingCodes[0]
+	} else if len(nextopiaIDs) > 0 {
+		account.ID = nextopiaIDs[0]
+	}
+	return account
+}
+
+// systems says where the customer was found
+func systems(c *Customer) string {
+	switch {
+	case len(c.Salesforce) > 0 && len(c.Nextopia) > 0:
+		return "in Salesforce and Nextopia"
+	case len(c.Salesforce) > 0:
+		return "in Salesforce only"
+	default:
+		return "in Nextopia only"
+	}
+}
+
+func listOrNone(values []string) string {
+	if len(values) == 0 {
+		return "none"
+	}
+	return strings.Join(values, ", ")
+}
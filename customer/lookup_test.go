@@ -0,0 +1,159 @@
+package customer
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/nextopia"
+	"github.com/searchspring/nebo/salesforce"
+	"github.com/simpleforce/simpleforce"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSalesforce struct {
+	accounts []*salesforce.Account
+	err      error
+}
+
+func (f *fakeSalesforce) Query(search string, offset int) ([]byte, error) { return nil, nil }
+func (f *fakeSalesforce) IDQuery(search string) ([]byte, error)           { return nil, nil }
+func (f *fakeSalesforce) Show(term string) ([]byte, error)                { return nil, nil }
+func (f *fakeSalesforce) Accounts(search string) ([]*salesforce.Account, error) {
+	return f.accounts, f.err
+}
+func (f *fakeSalesforce) ResultToMessage(search string, offset int, result *simpleforce.QueryResult) ([]byte, error) {
+	return nil, nil
+}
+
+type fakeNextopia struct {
+	accounts []*nextopia.Account
+	err      error
+	searches []string
+}
+
+func (f *fakeNextopia) Query(search string, offset int) ([]byte, error) { return nil, nil }
+func (f *fakeNextopia) Refresh() ([]byte, error)                        { return nil, nil }
+func (f *fakeNextopia) Accounts(search string) ([]*nextopia.Account, error) {
+	f.searches = append(f.searches, search)
+	return f.accounts, f.err
+}
+
+var sfAccounts = []*salesforce.Account{
+	{ID: "001A", Name: "Shoes Inc", Website: "shoes.com", Manager: "Ashley Hilton", Active: "Active", MRR: 100, Platform: "Shopify", TrackingCode: "abc123", Link: "https://searchspring.my.salesforce.com/001A"},
+	{ID: "001B", Name: "Boots", Website: "boots.com", Manager: "unknown", Active: "Not active", MRR: -1, Platform: "Magento", TrackingCode: "def456"},
+}
+
+var nxAccounts = []*nextopia.Account{
+	{ID1: "nx1", Name: "ec_shoescom", Status: "ACTIVE", URL: "www.Shoes.com", Plan: "Professional"},
+	{ID1: "nx2", Name: "ec_shoescom2", Status: "ACTIVE", URL: "shoes.com", Plan: "Trial"},
+	{ID1: "nx3", Name: "ec_sandalscom", Status: "INACTIVE", URL: "sandals.com", Plan: "Trial"},
+}
+
+func TestDomain(t *testing.T) {
+	for _, website := range []string{"shoes.com", "https://www.Shoes.com/", "http://shoes.com/store?x=1", " www.shoes.com "} {
+		require.Equal(t, "shoes.com", Domain(website), website)
+	}
+	require.Equal(t, "", Domain(""))
+}
+
+func TestMergeByDomain(t *testing.T) {
+	customers := Merge(sfAccounts, nxAccounts)
+	require.Len(t, customers, 3)
+	require.Equal(t, "shoes.com", customers[0].Domain)
+	require.Equal(t, sfAccounts[:1], customers[0].Salesforce)
+	require.Equal(t, nxAccounts[:2], customers[0].Nextopia)
+	require.Equal(t, "boots.com", customers[1].Domain)
+	require.Empty(t, customers[1].Nextopia)
+	require.Equal(t, "sandals.com", customers[2].Domain)
+	require.Empty(t, customers[2].Salesforce)
+}
+
+func decode(t *testing.T, response []byte) *slack.Msg {
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	return msg
+}
+
+func sections(msg *slack.Msg) []*slack.SectionBlock {
+	found := []*slack.SectionBlock{}
+	for _, block := range msg.Blocks.BlockSet[1:] {
+		if section, ok := block.(*slack.SectionBlock); ok {
+			found = append(found, section)
+		}
+	}
+	return found
+}
+
+func TestLookupShowsOneCardPerCustomer(t *testing.T) {
+	response, err := Lookup(&fakeSalesforce{accounts: sfAccounts}, &fakeNextopia{accounts: nxAccounts}, " shoes ", 0)
+	require.Nil(t, err)
+	msg := decode(t, response)
+	require.Equal(t, "Customers matching: shoes", msg.Text)
+	cards := sections(msg)
+	require.Len(t, cards, 3)
+	require.Equal(t, "*shoes.com* (Active)", cards[0].Text.Text)
+	require.Equal(t, "*Tracking code:*\nabc123", cards[0].Fields[0].Text)
+	require.Equal(t, "*Nextopia IDs:*\nnx1, nx2", cards[0].Fields[1].Text)
+	require.Equal(t, "*Rep:*\nAshley Hilton", cards[0].Fields[2].Text)
+	require.Equal(t, "https://searchspring.my.salesforce.com/001A", cards[0].Accessory.OverflowElement.Options[0].URL)
+	require.Equal(t, "copy_id:abc123", cards[0].Accessory.OverflowElement.Options[1].Value)
+	context := msg.Blocks.BlockSet[2].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	require.Equal(t, "Shoes Inc · in Salesforce and Nextopia", context.Text)
+
+	require.Equal(t, "*Nextopia IDs:*\nnone", cards[1].Fields[1].Text)
+	require.Equal(t, "*sandals.com* (Inactive)", cards[2].Text.Text)
+	require.Equal(t, "*Tracking code:*\nnone", cards[2].Fields[0].Text)
+	require.Equal(t, "*Nextopia plan:*\nTrial", cards[2].Fields[2].Text)
+}
+
+func TestLookupSaysWhichBackendFailed(t *testing.T) {
+	down := &url.Error{Op: "Get", URL: "https://client-report.nxtpd.com", Err: &httpclient.DownError{Name: "Nextopia"}}
+	response, err := Lookup(&fakeSalesforce{accounts: sfAccounts}, &fakeNextopia{err: down}, "shoes", 0)
+	require.Nil(t, err)
+	msg := decode(t, response)
+	require.Equal(t, "Customers matching: shoes\n:warning: Nextopia is down, showing Salesforce results only.", msg.Text)
+	require.Len(t, sections(msg), 2)
+
+	response, err = Lookup(&fakeSalesforce{err: errors.New("INVALID_SESSION_ID")}, &fakeNextopia{accounts: nxAccounts}, "shoes", 0)
+	require.Nil(t, err)
+	msg = decode(t, response)
+	require.Equal(t, "Customers matching: shoes\n:warning: Salesforce didn't answer, showing Nextopia results only.", msg.Text)
+	require.Len(t, sections(msg), 2)
+}
+
+func TestLookupWithoutASignedInBackend(t *testing.T) {
+	response, err := Lookup(nil, &fakeNextopia{accounts: nxAccounts}, "shoes", 0)
+	require.Nil(t, err)
+	msg := decode(t, response)
+	require.Equal(t, "Customers matching: shoes\n:warning: I couldn't sign in to Salesforce, showing Nextopia results only.", msg.Text)
+	require.Len(t, sections(msg), 2)
+
+	response, err = Lookup(&fakeSalesforce{accounts: sfAccounts}, nil, "shoes", 0)
+	require.Nil(t, err)
+	require.Equal(t, "Customers matching: shoes\n:warning: I couldn't sign in to Nextopia, showing Salesforce results only.", decode(t, response).Text)
+
+	_, err = Lookup(nil, nil, "shoes", 0)
+	require.Equal(t, errSignIn, err)
+}
+
+func TestLookupFailsWhenBothBackendsFail(t *testing.T) {
+	_, err := Lookup(&fakeSalesforce{err: errors.New("salesforce broke")}, &fakeNextopia{err: errors.New("nextopia broke")}, "shoes", 0)
+	require.EqualError(t, err, "salesforce broke")
+}
+
+func TestLookupSearchesNextopiaWithoutFilters(t *testing.T) {
+	nx := &fakeNextopia{accounts: nxAccounts}
+	_, err := Lookup(&fakeSalesforce{accounts: sfAccounts}, nx, `shoes platform:magento rep:"Ashley Hilton" mrr>100`, 0)
+	require.Nil(t, err)
+	require.Equal(t, []string{"shoes"}, nx.searches)
+
+	nx = &fakeNextopia{accounts: nxAccounts}
+	response, err := Lookup(&fakeSalesforce{accounts: sfAccounts[1:]}, nx, "platform:magento", 0)
+	require.Nil(t, err)
+	require.Empty(t, nx.searches)
+	require.Len(t, sections(decode(t, response)), 1)
+}
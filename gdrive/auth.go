@@ -0,0 +1,24 @@
+package gdrive
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// scope lets the service account copy and edit the files it has been given access to
+const scope = "https://www.googleapis.com/auth/drive"
+
+// signIn returns a client that calls google as the service account in the JSON key downloaded from
+// the google cloud console. Access tokens are fetched through base and reused until they expire.
+func signIn(serviceAccountKey string, base *http.Client) (*http.Client, error) {
+	config, err := google.JWTConfigFromJSON([]byte(serviceAccountKey), scope)
+	if err != nil {
+		return nil, fmt.Errorf("reading service account key: %w", err)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, base)
+	return config.Client(ctx), nil
+}
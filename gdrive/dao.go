@@ -0,0 +1,152 @@
+// Package gdrive makes documents in Google Drive from templates, signed in as a service account.
+package gdrive
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sort"
+	"time"
+
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/validator"
+)
+
+// DefaultDriveURL is the google drive API
+const DefaultDriveURL = "https://www.googleapis.com"
+
+// DefaultDocsURL is the google docs API
+const DefaultDocsURL = "https://docs.googleapis.com"
+
+// DAO acts as the google drive DAO
+type DAO interface {
+	CopyTemplate(templateID string, folderID string, name string, fields map[string]string) (string, error)
+}
+
+// DAOImpl defines the properties of the DAO
+type DAOImpl struct {
+	Client   *http.Client
+	DriveURL string
+	DocsURL  string
+}
+
+// NewDAO returns the google drive DAO signed in with the service account's JSON key, or nil if there
+// is no key or it cannot be read
+func NewDAO(serviceAccountKey string) DAO {
+	if validator.ContainsEmptyString(serviceAccountKey) {
+		return nil
+	}
+	client, err := signIn(serviceAccountKey, httpclient.New("Google Drive", 10*time.Second))
+	if err != nil {
+		log.Println(err.Error())
+		return nil
+	}
+	return &DAOImpl{
+		Client:   client,
+		DriveURL: DefaultDriveURL,
+		DocsURL:  DefaultDocsURL,
+	}
+}
+
+// DocumentURL is where a google doc is opened
+func DocumentURL(id string) string {
+	return "https://docs.google.com/document/d/" + id + "/edit"
+}
+
+// https://developers.google.com/drive/api/v3/reference/files/copy
+type copyRequest struct {
+	Name    string   `json:"name"`
+	Parents []string `json:"parents"`
+}
+
+type copyResponse struct {
+	ID string `json:"id"`
+}
+
+// https://developers.google.com/docs/api/reference/rest/v1/documents/batchUpdate
+type batchUpdateRequest struct {
+	Requests []*docsRequest `json:"requests"`
+}
+
+type docsRequest struct {
+	ReplaceAllText *replaceAllText `json:"replaceAllText"`
+}
+
+type replaceAllText struct {
+	ContainsText *substringMatch `json:"containsText"`
+	ReplaceText  string          `json:"replaceText"`
+}
+
+type substringMatch struct {
+	Text      string `json:"text"`
+	MatchCase bool   `json:"matchCase"`
+}
+
+// CopyTemplate copies the template google doc into the folder under the name and replaces each
+// {{key}} in the copy with the field's value, returning a link to the copy. If the fields cannot be
+// filled in the copy is still returned, with the placeholders left for people to fill in.
+func (d *DAOImpl) CopyTemplate(templateID string, folderID string, name string, fields map[string]string) (string, error) {
+	copied := &copyResponse{}
+	err := d.call(d.DriveURL+"/drive/v3/files/"+url.PathEscape(templateID)+"/copy?supportsAllDrives=true",
+		&copyRequest{Name: name, Parents: []string{folderID}}, copied)
+	if err != nil {
+		return "", err
+	}
+	if copied.ID == "" {
+		return "", fmt.Errorf("google drive copied %s without returning an id", templateID)
+	}
+	if len(fields) == 0 {
+		return DocumentURL(copied.ID), nil
+	}
+
+	keys := []string{}
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	update := &batchUpdateRequest{}
+	for _, key := range keys {
+		update.Requests = append(update.Requests, &docsRequest{ReplaceAllText: &replaceAllText{
+			ContainsText: &substringMatch{Text: "{{" + key + "}}", MatchCase: true},
+			ReplaceText:  fields[key],
+		}})
+	}
+	err = d.call(d.DocsURL+"/v1/documents/"+url.PathEscape(copied.ID)+":batchUpdate", update, nil)
+	if err != nil {
+		log.Println("filling in " + name + ": " + err.Error())
+	}
+	return DocumentURL(copied.ID), nil
+}
+
+// call posts the request as JSON and decodes the response into result, unless it is nil
+func (d *DAOImpl) call(endpoint string, request interface{}, result interface{}) error {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("google returned %d: %s", res.StatusCode, string(resBody))
+	}
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(resBody, result)
+}
@@ -0,0 +1,203 @@
+package gdrive
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDrive stands in for google sign in, drive and docs, recording what it was asked to do
+type fakeDrive struct {
+	server    *httptest.Server
+	key       *rsa.PrivateKey
+	failed    map[string]int
+	expiresIn int
+
+	mutex   sync.Mutex
+	tokens  int
+	copies  []*copyRequest
+	updates []*batchUpdateRequest
+	paths   []string
+}
+
+func newFakeDrive(t *testing.T, failed map[string]int) *fakeDrive {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	f := &fakeDrive{key: key, failed: failed, expiresIn: 3600}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.paths = append(f.paths, r.URL.Path)
+		for path, status := range f.failed {
+			if strings.HasSuffix(r.URL.Path, path) {
+				w.WriteHeader(status)
+				w.Write([]byte(`{"error":{"message":"nope"}}`))
+				return
+			}
+		}
+		switch {
+		case r.URL.Path == "/token":
+			require.Nil(t, r.ParseForm())
+			require.Equal(t, "urn:ietf:params:oauth:grant-type:jwt-bearer", r.PostForm.Get("grant_type"))
+			f.checkAssertion(t, r.PostForm.Get("assertion"))
+			f.tokens++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"access_token":"access-token","expires_in":` + strconv.Itoa(f.expiresIn) + `,"token_type":"Bearer"}`))
+		case r.URL.Path == "/drive/v3/files/template/copy":
+			require.Equal(t, "Bearer access-token", r.Header.Get("Authorization"))
+			require.Equal(t, "true", r.URL.Query().Get("supportsAllDrives"))
+			request := &copyRequest{}
+			require.Nil(t, json.NewDecoder(r.Body).Decode(request))
+			f.copies = append(f.copies, request)
+			w.Write([]byte(`{"kind":"drive#file","id":"doc1","name":"` + request.Name + `"}`))
+		case r.URL.Path == "/v1/documents/doc1:batchUpdate":
+			require.Equal(t, "Bearer access-token", r.Header.Get("Authorization"))
+			request := &batchUpdateRequest{}
+			require.Nil(t, json.NewDecoder(r.Body).Decode(request))
+			f.updates = append(f.updates, request)
+			w.Write([]byte(`{"documentId":"doc1","replies":[]}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+// checkAssertion verifies the JWT was signed by the service account and asks for drive access
+func (f *fakeDrive) checkAssertion(t *testing.T, assertion string) {
+	parts := strings.Split(assertion, ".")
+	require.Len(t, parts, 3)
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	require.Nil(t, err)
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	require.Nil(t, rsa.VerifyPKCS1v15(&f.key.PublicKey, crypto.SHA256, sum[:], signature))
+	claims := map[string]interface{}{}
+	decoded, err := base64.RawURLEncoding.DecodeString(parts[1])
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(decoded, &claims))
+	require.Equal(t, "nebo@searchspring.iam.gserviceaccount.com", claims["iss"])
+	require.Equal(t, "https://www.googleapis.com/auth/drive", claims["scope"])
+	require.Equal(t, f.server.URL+"/token", claims["aud"])
+	require.Equal(t, claims["iat"].(float64)+3600, claims["exp"])
+}
+
+// keyJSON is a service account key like the one downloaded from the google cloud console
+func (f *fakeDrive) keyJSON(t *testing.T) string {
+	der, err := x509.MarshalPKCS8PrivateKey(f.key)
+	require.Nil(t, err)
+	key, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "nebo@searchspring.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    f.server.URL + "/token",
+	})
+	require.Nil(t, err)
+	return string(key)
+}
+
+func (f *fakeDrive) dao(t *testing.T) *DAOImpl {
+	client, err := signIn(f.keyJSON(t), &http.Client{})
+	require.Nil(t, err)
+	return &DAOImpl{Client: client, DriveURL: f.server.URL, DocsURL: f.server.URL}
+}
+
+func TestCopyTemplateFillsInFields(t *testing.T) {
+	fake := newFakeDrive(t, nil)
+	link, err := fake.dao(t).CopyTemplate("template", "folder", "FIRE-1 checkout is down 2020-10-29-14-08", map[string]string{
+		"title":  "checkout is down",
+		"leader": "Pat Doe",
+	})
+	require.Nil(t, err)
+	require.Equal(t, "https://docs.google.com/document/d/doc1/edit", link)
+
+	require.Len(t, fake.copies, 1)
+	require.Equal(t, &copyRequest{Name: "FIRE-1 checkout is down 2020-10-29-14-08", Parents: []string{"folder"}}, fake.copies[0])
+	require.Len(t, fake.updates, 1)
+	requests := fake.updates[0].Requests
+	require.Len(t, requests, 2)
+	require.Equal(t, "{{leader}}", requests[0].ReplaceAllText.ContainsText.Text)
+	require.True(t, requests[0].ReplaceAllText.ContainsText.MatchCase)
+	require.Equal(t, "Pat Doe", requests[0].ReplaceAllText.ReplaceText)
+	require.Equal(t, "{{title}}", requests[1].ReplaceAllText.ContainsText.Text)
+	require.Equal(t, "checkout is down", requests[1].ReplaceAllText.ReplaceText)
+}
+
+func TestAccessTokenIsReusedUntilItExpires(t *testing.T) {
+	fake := newFakeDrive(t, nil)
+	dao := fake.dao(t)
+	for i := 0; i < 2; i++ {
+		_, err := dao.CopyTemplate("template", "folder", "doc", nil)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 1, fake.tokens)
+	require.Empty(t, fake.updates)
+
+	fake = newFakeDrive(t, nil)
+	fake.expiresIn = 1
+	dao = fake.dao(t)
+	for i := 0; i < 2; i++ {
+		_, err := dao.CopyTemplate("template", "folder", "doc", nil)
+		require.Nil(t, err)
+	}
+	require.Equal(t, 2, fake.tokens)
+}
+
+func TestCopyTemplateFailures(t *testing.T) {
+	fake := newFakeDrive(t, map[string]int{"/token": http.StatusBadRequest})
+	_, err := fake.dao(t).CopyTemplate("template", "folder", "doc", nil)
+	require.Contains(t, err.Error(), "oauth2: cannot fetch token: 400 Bad Request")
+	require.Contains(t, err.Error(), `{"error":{"message":"nope"}}`)
+
+	fake = newFakeDrive(t, map[string]int{"/copy": http.StatusNotFound})
+	_, err = fake.dao(t).CopyTemplate("template", "folder", "doc", nil)
+	require.EqualError(t, err, `google returned 404: {"error":{"message":"nope"}}`)
+}
+
+func TestCopyTemplateKeepsTheCopyWhenFillingInFails(t *testing.T) {
+	fake := newFakeDrive(t, map[string]int{":batchUpdate": http.StatusForbidden})
+	link, err := fake.dao(t).CopyTemplate("template", "folder", "doc", map[string]string{"title": "checkout is down"})
+	require.Nil(t, err)
+	require.Equal(t, "https://docs.google.com/document/d/doc1/edit", link)
+}
+
+func TestNewDAO(t *testing.T) {
+	fake := newFakeDrive(t, nil)
+	dao := NewDAO(fake.keyJSON(t)).(*DAOImpl)
+	require.Equal(t, DefaultDriveURL, dao.DriveURL)
+	require.Equal(t, DefaultDocsURL, dao.DocsURL)
+	require.Nil(t, NewDAO(""))
+	require.Nil(t, NewDAO("not json"))
+	require.Nil(t, NewDAO(`{"type":"authorized_user","client_email":"nebo@searchspring.iam.gserviceaccount.com"}`))
+}
+
+func TestSignInReadsPKCS1Keys(t *testing.T) {
+	fake := newFakeDrive(t, nil)
+	key := string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(fake.key)}))
+	keyJSON, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "nebo@searchspring.iam.gserviceaccount.com",
+		"private_key":  key,
+		"token_uri":    fake.server.URL + "/token",
+	})
+	require.Nil(t, err)
+	client, err := signIn(string(keyJSON), &http.Client{})
+	require.Nil(t, err)
+	dao := &DAOImpl{Client: client, DriveURL: fake.server.URL, DocsURL: fake.server.URL}
+	_, err = dao.CopyTemplate("template", "folder", "doc", nil)
+	require.Nil(t, err)
+	require.Equal(t, 1, fake.tokens)
+}
@@ -8,4 +8,5 @@ require (
 	github.com/nlopes/slack v0.6.0
 	github.com/simpleforce/simpleforce v0.0.0-20201016131803-2062cbbdbb89
 	github.com/stretchr/testify v1.6.1
+	golang.org/x/oauth2 v0.0.0-20210113205817-d3ed898aa8a3
 )
@@ -0,0 +1,102 @@
+package httpclient
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultThreshold is how many failures in a row open a breaker
+const DefaultThreshold = 5
+
+// DefaultCooldown is how long an open breaker fails fast before letting a request try the backend
+const DefaultCooldown = 30 * time.Second
+
+// DownError is returned without calling the backend while its breaker is open
+type DownError struct {
+	Name string
+}
+
+func (e *DownError) Error() string {
+	return e.Name + " is down"
+}
+
+// IsDown reports whether the error, or one it wraps, is a DownError, and for which backend
+func IsDown(err error) (string, bool) {
+	down := &DownError{}
+	if errors.As(err, &down) {
+		return down.Name, true
+	}
+	return "", false
+}
+
+// Breaker stops calls to a backend once it has failed Threshold times in a row. After Cooldown one
+// call is let through; if it succeeds the breaker closes again, otherwise it stays open for another
+// Cooldown.
+type Breaker struct {
+	Threshold int
+	Cooldown  time.Duration
+	Now       func() time.Time
+
+	mutex    sync.Mutex
+	failures int
+	openedAt time.Time
+	trial    bool
+}
+
+// NewBreaker returns a closed breaker with the default threshold and cooldown
+func NewBreaker() *Breaker {
+	return &Breaker{
+		Threshold: DefaultThreshold,
+		Cooldown:  DefaultCooldown,
+		Now:       time.Now,
+	}
+}
+
+var breakers = map[string]*Breaker{}
+var breakersMutex sync.Mutex
+
+// BreakerFor returns the breaker shared by every client of the named backend
+func BreakerFor(name string) *Breaker {
+	breakersMutex.Lock()
+	defer breakersMutex.Unlock()
+	b, ok := breakers[name]
+	if !ok {
+		b = NewBreaker()
+		breakers[name] = b
+	}
+	return b
+}
+
+// Allow reports whether a call may go to the backend
+func (b *Breaker) Allow() bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.failures < b.Threshold {
+		return true
+	}
+	if b.trial || b.Now().Sub(b.openedAt) < b.Cooldown {
+		return false
+	}
+	b.trial = true
+	return true
+}
+
+// Success closes the breaker
+func (b *Breaker) Success() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures = 0
+	b.trial = false
+}
+
+// Failure counts a failed call, opening the breaker once there have been Threshold in a row
+func (b *Breaker) Failure() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.failures++
+	b.trial = false
+	if b.failures >= b.Threshold {
+		b.openedAt = b.Now()
+	}
+}
@@ -0,0 +1,197 @@
+// Package httpclient builds the http clients nebo uses to call other services. Each call gets its own
+// deadline, failed calls are retried with jittered backoff within it, and a backend that keeps failing
+// is reported as down straight away instead of holding up every command.
+package httpclient
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptrace"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetries is how many times a failed call is tried again
+const DefaultRetries = 2
+
+// DefaultBackoff is the wait before the first retry, it doubles for each retry after that
+const DefaultBackoff = 200 * time.Millisecond
+
+// maxRetryAfter caps how long a Retry-After header can make a retry wait
+const maxRetryAfter = 5 * time.Second
+
+// Transport retries calls to the named backend and trips its breaker when it keeps failing. Each
+// attempt is given Timeout and the whole call, retries and backoff included, is given Deadline.
+type Transport struct {
+	Name     string
+	Timeout  time.Duration
+	Deadline time.Duration
+	Retries  int
+	Backoff  time.Duration
+	Breaker  *Breaker
+	Base     http.RoundTripper
+	Sleep    func(time.Duration)
+}
+
+// New returns a client for the named backend where each call, retries included, is given timeout.
+// Clients for the same backend share a breaker.
+func New(name string, timeout time.Duration) *http.Client {
+	return &http.Client{
+		Transport: &Transport{
+			Name:     name,
+			Timeout:  timeout,
+			Deadline: timeout,
+			Retries:  DefaultRetries,
+			Backoff:  DefaultBackoff,
+			Breaker:  BreakerFor(name),
+			Base:     http.DefaultTransport,
+			Sleep:    time.Sleep,
+		},
+	}
+}
+
+// RoundTrip sends the request, trying again after network errors, 429s and 5xxs. A request that is
+// not idempotent, such as a POST creating a note or a channel, is only tried again if it never reached
+// the backend or the backend refused it with a 429, so that it is never done twice. Requests whose
+// body cannot be replayed are only tried once.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Breaker != nil && !t.Breaker.Allow() {
+		return nil, &DownError{Name: t.Name}
+	}
+	if t.Deadline <= 0 {
+		return t.attempts(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.Deadline)
+	res, err := t.attempts(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// attempts tries the call until it works, cannot be retried or there is no time left to wait for
+// another attempt
+func (t *Transport) attempts(req *http.Request) (*http.Response, error) {
+	for attempt := 0; ; attempt++ {
+		try := req
+		if attempt > 0 && req.Body != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			try = req.Clone(req.Context())
+			try.Body = body
+		}
+		written := int32(0)
+		try = try.WithContext(httptrace.WithClientTrace(try.Context(), &httptrace.ClientTrace{
+			WroteHeaders: func() { atomic.StoreInt32(&written, 1) },
+		}))
+		res, err := t.send(try)
+		canRetry := attempt < t.Retries && (req.Body == nil || req.GetBody != nil) && req.Context().Err() == nil
+		safe := idempotent(req) || unsent(res, atomic.LoadInt32(&written) == 1)
+		if !retryable(res, err) || !canRetry || !safe {
+			t.record(res, err)
+			return res, err
+		}
+		wait := t.backoff(attempt, res)
+		if deadline, ok := req.Context().Deadline(); ok && time.Until(deadline) <= wait {
+			t.record(res, err)
+			return res, err
+		}
+		if res != nil {
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		}
+		t.Sleep(wait)
+	}
+}
+
+// send makes one attempt at the call, cancelling it if it takes longer than the timeout
+func (t *Transport) send(req *http.Request) (*http.Response, error) {
+	if t.Timeout <= 0 {
+		return t.Base.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
+	res, err := t.Base.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// record tells the breaker how the call went. A 429 means the backend is up but busy.
+func (t *Transport) record(res *http.Response, err error) {
+	if t.Breaker == nil {
+		return
+	}
+	if err != nil || res.StatusCode >= http.StatusInternalServerError {
+		t.Breaker.Failure()
+		return
+	}
+	t.Breaker.Success()
+}
+
+// idempotent reports whether sending the request twice does the same as sending it once, as
+// net/http decides for its own retries
+func idempotent(req *http.Request) bool {
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != "" || req.Header.Get("X-Idempotency-Key") != ""
+}
+
+// unsent reports whether the backend did nothing with the attempt: it never got the request, or
+// turned it away with a 429
+func unsent(res *http.Response, written bool) bool {
+	if res != nil {
+		return res.StatusCode == http.StatusTooManyRequests
+	}
+	return !written
+}
+
+func retryable(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	return res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= http.StatusInternalServerError
+}
+
+// backoff waits somewhere between half and all of the doubled backoff, or as long as the backend
+// asks in Retry-After
+func (t *Transport) backoff(attempt int, res *http.Response) time.Duration {
+	if res != nil {
+		if seconds, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+			wait := time.Duration(seconds) * time.Second
+			if wait > maxRetryAfter {
+				wait = maxRetryAfter
+			}
+			return wait
+		}
+	}
+	wait := t.Backoff << uint(attempt)
+	if wait <= 0 {
+		return 0
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// cancelBody releases the attempt's timeout once the body has been read
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,261 @@
+package httpclient
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testClient returns a client for the server's responses that records its waits instead of sleeping
+func testClient(breaker *Breaker) (*http.Client, *[]time.Duration) {
+	waits := []time.Duration{}
+	return &http.Client{
+		Transport: &Transport{
+			Name:    "Nextopia",
+			Timeout: time.Second,
+			Retries: DefaultRetries,
+			Backoff: DefaultBackoff,
+			Breaker: breaker,
+			Base:    http.DefaultTransport,
+			Sleep:   func(d time.Duration) { waits = append(waits, d) },
+		},
+	}, &waits
+}
+
+// statusServer answers with each status in turn, repeating the last one
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *[]string) {
+	bodies := []string{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(r.Body)
+		require.Nil(t, err)
+		bodies = append(bodies, string(body))
+		status := statuses[0]
+		if len(statuses) > 1 {
+			statuses = statuses[1:]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "1")
+		}
+		w.WriteHeader(status)
+		w.Write([]byte("ok"))
+	}))
+	return server, &bodies
+}
+
+func TestRetriesServerErrorsWithBackoff(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+	client, waits := testClient(nil)
+
+	req, err := http.NewRequest(http.MethodPut, server.URL, strings.NewReader(`{"note":1}`))
+	require.Nil(t, err)
+	res, err := client.Do(req)
+	require.Nil(t, err)
+	defer res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, []string{`{"note":1}`, `{"note":1}`, `{"note":1}`}, *bodies)
+	require.Len(t, *waits, 2)
+	require.True(t, (*waits)[0] >= DefaultBackoff/2 && (*waits)[0] <= DefaultBackoff)
+	require.True(t, (*waits)[1] >= DefaultBackoff && (*waits)[1] <= 2*DefaultBackoff)
+}
+
+func TestDoesNotRetryPostsTheBackendReceived(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusBadGateway, http.StatusOK)
+	defer server.Close()
+	client, waits := testClient(nil)
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"note":1}`))
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadGateway, res.StatusCode)
+	require.Len(t, *bodies, 1)
+	require.Empty(t, *waits)
+}
+
+func TestRetriesPostsTheBackendTurnedAway(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusTooManyRequests, http.StatusOK)
+	defer server.Close()
+	client, _ := testClient(nil)
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"note":1}`))
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, *bodies, 2)
+}
+
+func TestRetriesPostsThatWereNeverSent(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusOK)
+	defer server.Close()
+	client, _ := testClient(nil)
+	refused := 0
+	transport := client.Transport.(*Transport)
+	transport.Base = roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if refused == 0 {
+			refused++
+			return nil, errors.New("connection refused")
+		}
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	res, err := client.Post(server.URL, "application/json", strings.NewReader(`{"note":1}`))
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, 1, refused)
+	require.Len(t, *bodies, 1)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+
+func TestRetriesTooManyRequestsAfterRetryAfter(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusTooManyRequests, http.StatusOK)
+	defer server.Close()
+	client, waits := testClient(nil)
+
+	res, err := client.Get(server.URL)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Len(t, *bodies, 2)
+	require.Equal(t, []time.Duration{time.Second}, *waits)
+}
+
+func TestGivesUpAfterRetries(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusInternalServerError)
+	defer server.Close()
+	client, _ := testClient(nil)
+
+	res, err := client.Get(server.URL)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusInternalServerError, res.StatusCode)
+	require.Len(t, *bodies, 1+DefaultRetries)
+}
+
+func TestDoesNotRetryClientErrors(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusBadRequest)
+	defer server.Close()
+	client, waits := testClient(nil)
+
+	res, err := client.Get(server.URL)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+	require.Len(t, *bodies, 1)
+	require.Empty(t, *waits)
+}
+
+func TestTimesOutEachAttempt(t *testing.T) {
+	release := make(chan bool)
+	attempts := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&attempts, 1) == 1 {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	defer close(release)
+	client, waits := testClient(nil)
+	client.Transport.(*Transport).Timeout = 50 * time.Millisecond
+
+	res, err := client.Get(server.URL)
+	require.Nil(t, err)
+	body, err := ioutil.ReadAll(res.Body)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, "ok", string(body))
+	require.Len(t, *waits, 1)
+}
+
+func TestGivesUpAtTheDeadline(t *testing.T) {
+	attempts := int32(0)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+	client, waits := testClient(nil)
+	transport := client.Transport.(*Transport)
+	transport.Timeout = 50 * time.Millisecond
+	transport.Deadline = 120 * time.Millisecond
+
+	start := time.Now()
+	_, err := client.Get(server.URL)
+	require.NotNil(t, err)
+	require.True(t, time.Since(start) < 200*time.Millisecond, time.Since(start).String())
+	require.True(t, atomic.LoadInt32(&attempts) < 1+DefaultRetries)
+	require.Empty(t, *waits)
+}
+
+func TestBreakerFailsFastWhenBackendIsDown(t *testing.T) {
+	server, bodies := statusServer(t, http.StatusServiceUnavailable)
+	defer server.Close()
+	now := time.Unix(1603980505, 0)
+	breaker := NewBreaker()
+	breaker.Threshold = 2
+	breaker.Now = func() time.Time { return now }
+	client, _ := testClient(breaker)
+
+	for i := 0; i < 2; i++ {
+		res, err := client.Get(server.URL)
+		require.Nil(t, err)
+		res.Body.Close()
+	}
+	require.Len(t, *bodies, 2*(1+DefaultRetries))
+
+	_, err := client.Get(server.URL)
+	name, down := IsDown(err)
+	require.True(t, down)
+	require.Equal(t, "Nextopia", name)
+	require.Contains(t, err.Error(), "Nextopia is down")
+	require.Len(t, *bodies, 2*(1+DefaultRetries))
+
+	// after the cooldown one call tries the backend again, and closes the breaker when it works
+	now = now.Add(DefaultCooldown)
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	res, err := client.Get(server.URL)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.True(t, breaker.Allow())
+}
+
+func TestBreakerStaysOpenWhenTrialFails(t *testing.T) {
+	now := time.Unix(1603980505, 0)
+	breaker := NewBreaker()
+	breaker.Threshold = 1
+	breaker.Now = func() time.Time { return now }
+	breaker.Failure()
+	require.False(t, breaker.Allow())
+
+	now = now.Add(DefaultCooldown)
+	require.True(t, breaker.Allow())
+	require.False(t, breaker.Allow())
+	breaker.Failure()
+	require.False(t, breaker.Allow())
+
+	now = now.Add(DefaultCooldown)
+	require.True(t, breaker.Allow())
+	breaker.Success()
+	require.True(t, breaker.Allow())
+	require.True(t, breaker.Allow())
+}
+
+func TestBreakerForIsShared(t *testing.T) {
+	require.True(t, BreakerFor("Productboard") == BreakerFor("Productboard"))
+	require.False(t, BreakerFor("Productboard") == BreakerFor("Slack"))
+}
@@ -0,0 +1,216 @@
+// Package incident remembers fires between commands: when each one started, who is handling it and
+// what happened along the way.
+package incident
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusOpen is the status of a fire that is still burning
+const StatusOpen = "open"
+
+// StatusResolved is the status of a fire that is out
+const StatusResolved = "resolved"
+
+// DefaultSeverity is the severity of a fire opened without one, 1 is the worst
+const DefaultSeverity = 2
+
+// the range of severities that can be given when opening a fire
+const (
+	highestSeverity = 1
+	lowestSeverity  = 4
+)
+
+// the roles people take in a fire
+const (
+	RoleLeader        = "leader"
+	RoleDocMaintainer = "doc maintainer"
+	RoleAnnouncer     = "announcer"
+)
+
+// ErrNotFound is returned when there is no incident to act on
+var ErrNotFound = errors.New("no open incident")
+
+// ErrAlreadyOpen is returned when a fire is opened in a channel that already has one
+var ErrAlreadyOpen = errors.New("there is already an open incident in this channel")
+
+// Event is one entry in an incident's timeline
+type Event struct {
+	At   time.Time `json:"at"`
+	User string    `json:"user"`
+	Text string    `json:"text"`
+}
+
+// Incident is a fire and the people handling it
+type Incident struct {
+	ID            string    `json:"id"`
+	Title         string    `json:"title"`
+	Channel       string    `json:"channel"`
+	Severity      int       `json:"severity"`
+	Status        string    `json:"status"`
+	Started       time.Time `json:"started"`
+	Ended         time.Time `json:"ended"`
+	Leader        string    `json:"leader"`
+	DocMaintainer string    `json:"docMaintainer"`
+	Announcer     string    `json:"announcer"`
+	Meet          string    `json:"meet"`
+	Doc           string    `json:"doc"`
+	Announcement  string    `json:"announcement"`
+	Timeline      []Event   `json:"timeline"`
+}
+
+// Open starts a fire in the channel. If the channel already has one it is returned with
+// ErrAlreadyOpen.
+func Open(store Store, title string, channel string, user string, severity int, now time.Time) (*Incident, error) {
+	existing, err := store.OpenIn(channel)
+	if err == nil {
+		return existing, ErrAlreadyOpen
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+	i := New(title, channel, user, severity, now)
+	err = store.Create(i)
+	if err == ErrAlreadyOpen {
+		// another fire was opened in the channel since it was checked
+		existing, err = store.OpenIn(channel)
+		if err != nil {
+			return nil, err
+		}
+		return existing, ErrAlreadyOpen
+	}
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// New is a fire opened by the user, which has no ID until it is created in a store
+func New(title string, channel string, user string, severity int, now time.Time) *Incident {
+	i := &Incident{
+		Title:    title,
+		Channel:  channel,
+		Severity: severity,
+		Status:   StatusOpen,
+		Started:  now,
+	}
+	i.Log(now, user, "opened the fire")
+	return i
+}
+
+// Close resolves the fire in the channel, returning ErrNotFound if there isn't one
+func Close(store Store, channel string, user string, now time.Time) (*Incident, error) {
+	i, err := store.OpenIn(channel)
+	if err != nil {
+		return nil, err
+	}
+	i.Status = StatusResolved
+	i.Ended = now
+	i.Log(now, user, "put the fire out")
+	err = store.Save(i)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// Assign gives the role to the user, taking it from whoever held it before
+func (i *Incident) Assign(role string, user string, now time.Time) error {
+	holder, err := i.holder(role)
+	if err != nil {
+		return err
+	}
+	if *holder == user {
+		return nil
+	}
+	*holder = user
+	i.Log(now, user, "took the role of "+role)
+	return nil
+}
+
+// Holder returns who holds the role, or "" if nobody does
+func (i *Incident) Holder(role string) string {
+	holder, err := i.holder(role)
+	if err != nil {
+		return ""
+	}
+	return *holder
+}
+
+func (i *Incident) holder(role string) (*string, error) {
+	switch role {
+	case RoleLeader:
+		return &i.Leader, nil
+	case RoleDocMaintainer:
+		return &i.DocMaintainer, nil
+	case RoleAnnouncer:
+		return &i.Announcer, nil
+	}
+	return nil, fmt.Errorf("unknown fire role %q", role)
+}
+
+// Log adds an event to the timeline
+func (i *Incident) Log(at time.Time, user string, text string) {
+	i.Timeline = append(i.Timeline, Event{At: at, User: user, Text: text})
+}
+
+// Duration is how long the fire burned, or has been burning so far if it is still open
+func (i *Incident) Duration(now time.Time) time.Duration {
+	if !i.Ended.IsZero() {
+		return i.Ended.Sub(i.Started)
+	}
+	return now.Sub(i.Started)
+}
+
+// Name is how the incident is referred to in messages, e.g. "FIRE-3 checkout is down (SEV1)", or
+// without the ID if it has none
+func (i *Incident) Name() string {
+	if i.ID == "" {
+		return fmt.Sprintf("%s (SEV%d)", i.Title, i.Severity)
+	}
+	return fmt.Sprintf("%s %s (SEV%d)", i.ID, i.Title, i.Severity)
+}
+
+// ParseSeverity takes a leading severity such as "sev1" off the text, returning the rest of the text
+// and the severity, or DefaultSeverity if there isn't one
+func ParseSeverity(text string) (string, int) {
+	text = strings.TrimSpace(text)
+	fields := strings.Fields(text)
+	if len(fields) == 0 {
+		return text, DefaultSeverity
+	}
+	first := strings.ToLower(fields[0])
+	if !strings.HasPrefix(first, "sev") {
+		return text, DefaultSeverity
+	}
+	severity, err := strconv.Atoi(first[len("sev"):])
+	if err != nil || severity < highestSeverity || severity > lowestSeverity {
+		return text, DefaultSeverity
+	}
+	return strings.TrimSpace(text[len(fields[0]):]), severity
+}
+
+// FormatDuration rounds the duration down to the minute for people to read, e.g. "1d 2h 5m"
+func FormatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return "less than a minute"
+	}
+	minutes := int(d / time.Minute)
+	days, hours := minutes/(24*60), minutes/60%24
+	minutes = minutes % 60
+	parts := []string{}
+	if days > 0 {
+		parts = append(parts, strconv.Itoa(days)+"d")
+	}
+	if hours > 0 {
+		parts = append(parts, strconv.Itoa(hours)+"h")
+	}
+	if minutes > 0 {
+		parts = append(parts, strconv.Itoa(minutes)+"m")
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,136 @@
+package incident
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func testStore(t *testing.T) *FileStore {
+	return NewFileStore(filepath.Join(t.TempDir(), "incidents.json"))
+}
+
+func TestNewHasNoID(t *testing.T) {
+	start := time.Unix(1603980505, 0).UTC()
+	i := New("checkout is down", "C1", "U1", 1, start)
+	require.Equal(t, "", i.ID)
+	require.Equal(t, StatusOpen, i.Status)
+	require.Equal(t, "checkout is down (SEV1)", i.Name())
+	require.Equal(t, []Event{{At: start, User: "U1", Text: "opened the fire"}}, i.Timeline)
+}
+
+// racingStore misses the open incident the first time, as if it was opened after the check
+type racingStore struct {
+	*FileStore
+	checked bool
+}
+
+func (s *racingStore) OpenIn(channel string) (*Incident, error) {
+	if !s.checked {
+		s.checked = true
+		return nil, ErrNotFound
+	}
+	return s.FileStore.OpenIn(channel)
+}
+
+func TestOpenReturnsTheFireOpenedAtTheSameTime(t *testing.T) {
+	store := testStore(t)
+	now := time.Unix(1603980505, 0).UTC()
+	first, err := Open(store, "checkout is down", "C1", "U1", 1, now)
+	require.Nil(t, err)
+	second, err := Open(&racingStore{FileStore: store}, "checkout is still down", "C1", "U2", 1, now)
+	require.Equal(t, ErrAlreadyOpen, err)
+	require.Equal(t, first, second)
+}
+
+func TestOpenAndClose(t *testing.T) {
+	store := testStore(t)
+	start := time.Unix(1603980505, 0).UTC()
+	i, err := Open(store, "checkout is down", "C1", "U1", 1, start)
+	require.Nil(t, err)
+	require.Equal(t, "FIRE-1", i.ID)
+	require.Equal(t, StatusOpen, i.Status)
+	require.Equal(t, "FIRE-1 checkout is down (SEV1)", i.Name())
+
+	closed, err := Close(store, "C1", "U2", start.Add(83*time.Minute))
+	require.Nil(t, err)
+	require.Equal(t, "FIRE-1", closed.ID)
+	require.Equal(t, StatusResolved, closed.Status)
+	require.Equal(t, 83*time.Minute, closed.Duration(start.Add(24*time.Hour)))
+	require.Len(t, closed.Timeline, 2)
+	require.Equal(t, Event{At: start, User: "U1", Text: "opened the fire"}, closed.Timeline[0])
+	require.Equal(t, "U2", closed.Timeline[1].User)
+
+	_, err = Close(store, "C1", "U2", start)
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestOpenTwiceInAChannel(t *testing.T) {
+	store := testStore(t)
+	now := time.Unix(1603980505, 0)
+	first, err := Open(store, "checkout is down", "C1", "U1", 2, now)
+	require.Nil(t, err)
+	again, err := Open(store, "search is down", "C1", "U2", 2, now)
+	require.Equal(t, ErrAlreadyOpen, err)
+	require.Equal(t, first.ID, again.ID)
+
+	other, err := Open(store, "search is down", "C2", "U2", 2, now)
+	require.Nil(t, err)
+	require.Equal(t, "FIRE-2", other.ID)
+}
+
+func TestDurationOfAnOpenFire(t *testing.T) {
+	start := time.Unix(1603980505, 0).UTC()
+	i := &Incident{Started: start}
+	require.Equal(t, 5*time.Minute, i.Duration(start.Add(5*time.Minute)))
+}
+
+func TestParseSeverity(t *testing.T) {
+	cases := []struct {
+		text     string
+		title    string
+		severity int
+	}{
+		{"checkout is down", "checkout is down", DefaultSeverity},
+		{"sev1 checkout is down", "checkout is down", 1},
+		{" SEV3  checkout", "checkout", 3},
+		{"sev4", "", 4},
+		{"sev9 checkout", "sev9 checkout", DefaultSeverity},
+		{"several pages are down", "several pages are down", DefaultSeverity},
+		{"", "", DefaultSeverity},
+	}
+	for _, c := range cases {
+		title, severity := ParseSeverity(c.text)
+		require.Equal(t, c.title, title, c.text)
+		require.Equal(t, c.severity, severity, c.text)
+	}
+}
+
+func TestFormatDuration(t *testing.T) {
+	require.Equal(t, "less than a minute", FormatDuration(59*time.Second))
+	require.Equal(t, "5m", FormatDuration(5*time.Minute+30*time.Second))
+	require.Equal(t, "1h 23m", FormatDuration(83*time.Minute))
+	require.Equal(t, "2h", FormatDuration(2*time.Hour))
+	require.Equal(t, "1d 2h 5m", FormatDuration(26*time.Hour+5*time.Minute))
+}
+
+func TestAssign(t *testing.T) {
+	now := time.Unix(1603980505, 0).UTC()
+	i := &Incident{Leader: "U1"}
+	require.Nil(t, i.Assign(RoleLeader, "U1", now))
+	require.Empty(t, i.Timeline)
+
+	require.Nil(t, i.Assign(RoleLeader, "U2", now))
+	require.Nil(t, i.Assign(RoleDocMaintainer, "U3", now))
+	require.Nil(t, i.Assign(RoleAnnouncer, "U3", now))
+	require.Equal(t, "U2", i.Holder(RoleLeader))
+	require.Equal(t, "U3", i.DocMaintainer)
+	require.Equal(t, "U3", i.Announcer)
+	require.Equal(t, Event{At: now, User: "U2", Text: "took the role of leader"}, i.Timeline[0])
+	require.Len(t, i.Timeline, 3)
+
+	require.EqualError(t, i.Assign("cook", "U4", now), `unknown fire role "cook"`)
+	require.Equal(t, "", i.Holder("cook"))
+}
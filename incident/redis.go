@@ -0,0 +1,199 @@
+package incident
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/validator"
+)
+
+// redisPrefix starts every key nebo keeps in redis
+const redisPrefix = "nebo:fire:"
+
+// RedisStore keeps incidents in redis through its REST API, as offered by Upstash and Vercel KV, so
+// that every instance of every function sees the same fires
+type RedisStore struct {
+	Client *http.Client
+	URL    string
+	Token  string
+}
+
+// NewRedisStore returns a store that keeps incidents in the redis at storeURL, or nil if the URL or
+// token is blank
+func NewRedisStore(storeURL string, token string) Store {
+	if validator.ContainsEmptyString(storeURL, token) {
+		return nil
+	}
+	return &RedisStore{
+		Client: httpclient.New("Incident store", 5*time.Second),
+		URL:    strings.TrimSuffix(storeURL, "/"),
+		Token:  token,
+	}
+}
+
+// Create gives the incident the next ID and saves it, claiming its channel first so that of two fires
+// opened in a channel at the same time only one is created
+func (s *RedisStore) Create(i *Incident) error {
+	var lastID int
+	found, err := s.command(&lastID, "INCR", redisPrefix+"lastId")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("redis did not count the incident")
+	}
+	i.ID = idPrefix + strconv.Itoa(lastID)
+	// NX only sets a key that does not exist, redis answers null if the channel is already claimed
+	claimed, err := s.command(nil, "SET", openKey(i.Channel), i.ID, "NX")
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		_, err = s.OpenIn(i.Channel)
+		if err != ErrNotFound {
+			if err == nil {
+				err = ErrAlreadyOpen
+			}
+			return err
+		}
+		// the claim is left over from a fire that was closed or never saved
+		_, err = s.command(nil, "SET", openKey(i.Channel), i.ID)
+		if err != nil {
+			return err
+		}
+	}
+	body, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	_, err = s.command(nil, "SET", incidentKey(i.ID), string(body))
+	return err
+}
+
+// Save replaces the stored incident with the same ID
+func (s *RedisStore) Save(i *Incident) error {
+	body, err := json.Marshal(i)
+	if err != nil {
+		return err
+	}
+	// XX only replaces a key that exists, redis answers null if it does not
+	found, err := s.command(nil, "SET", incidentKey(i.ID), string(body), "XX")
+	if err != nil {
+		return err
+	}
+	if !found {
+		return ErrNotFound
+	}
+	return s.saveOpen(i)
+}
+
+// Get returns the incident with the ID
+func (s *RedisStore) Get(id string) (*Incident, error) {
+	var body string
+	found, err := s.command(&body, "GET", incidentKey(id))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	i := &Incident{}
+	err = json.Unmarshal([]byte(body), i)
+	if err != nil {
+		return nil, err
+	}
+	return i, nil
+}
+
+// OpenIn returns the open incident in the channel
+func (s *RedisStore) OpenIn(channel string) (*Incident, error) {
+	var id string
+	found, err := s.command(&id, "GET", openKey(channel))
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+	i, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if i.Channel != channel || i.Status != StatusOpen {
+		return nil, ErrNotFound
+	}
+	return i, nil
+}
+
+// saveOpen points the channel at the incident while it is open, and at nothing once it is resolved
+func (s *RedisStore) saveOpen(i *Incident) error {
+	if i.Status == StatusOpen {
+		_, err := s.command(nil, "SET", openKey(i.Channel), i.ID)
+		return err
+	}
+	var current string
+	found, err := s.command(&current, "GET", openKey(i.Channel))
+	if err != nil || !found || current != i.ID {
+		return err
+	}
+	_, err = s.command(nil, "DEL", openKey(i.Channel))
+	return err
+}
+
+func incidentKey(id string) string {
+	return redisPrefix + "incident:" + id
+}
+
+func openKey(channel string) string {
+	return redisPrefix + "open:" + channel
+}
+
+// https://docs.upstash.com/redis/features/restapi
+type redisResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  string          `json:"error"`
+}
+
+// command runs the redis command and decodes its result into result, unless it is nil. It reports
+// whether there was a result, redis answers null for a missing key.
+func (s *RedisStore) command(result interface{}, args ...string) (bool, error) {
+	body, err := json.Marshal(args)
+	if err != nil {
+		return false, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+s.Token)
+	res, err := s.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return false, err
+	}
+	response := &redisResponse{}
+	err = json.Unmarshal(resBody, response)
+	if err != nil || res.StatusCode != http.StatusOK || response.Error != "" {
+		return false, fmt.Errorf("redis %s returned %d: %s", args[0], res.StatusCode, string(resBody))
+	}
+	if len(response.Result) == 0 || string(response.Result) == "null" {
+		return false, nil
+	}
+	if result == nil {
+		return true, nil
+	}
+	return true, json.Unmarshal(response.Result, result)
+}
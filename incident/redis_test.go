@@ -0,0 +1,136 @@
+package incident
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRedis answers the redis REST API from a map, the way Upstash does
+func fakeRedis(t *testing.T) (*httptest.Server, map[string]string) {
+	keys := map[string]string{}
+	var mutex sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		if r.Header.Get("Authorization") != "Bearer redis-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":"Unauthorized"}`))
+			return
+		}
+		args := []string{}
+		require.Nil(t, json.NewDecoder(r.Body).Decode(&args))
+		var result interface{}
+		switch args[0] {
+		case "GET":
+			if value, ok := keys[args[1]]; ok {
+				result = value
+			}
+		case "SET":
+			_, exists := keys[args[1]]
+			if len(args) < 4 || (args[3] == "XX") == exists {
+				keys[args[1]] = args[2]
+				result = "OK"
+			}
+		case "DEL":
+			delete(keys, args[1])
+			result = 1
+		case "INCR":
+			n, _ := strconv.Atoi(keys[args[1]])
+			keys[args[1]] = strconv.Itoa(n + 1)
+			result = n + 1
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"ERR unknown command"}`))
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server, keys
+}
+
+func TestRedisStoreIsSharedBetweenInstances(t *testing.T) {
+	server, _ := fakeRedis(t)
+	now := time.Unix(1603980505, 0).UTC()
+	i, err := Open(NewRedisStore(server.URL, "redis-token"), "checkout is down", "C1", "U1", 1, now)
+	require.Nil(t, err)
+	require.Equal(t, "FIRE-1", i.ID)
+
+	other := NewRedisStore(server.URL+"/", "redis-token")
+	found, err := other.OpenIn("C1")
+	require.Nil(t, err)
+	require.Equal(t, i, found)
+	next, err := Open(other, "search is slow", "C2", "U2", 2, now)
+	require.Nil(t, err)
+	require.Equal(t, "FIRE-2", next.ID)
+
+	require.Nil(t, found.Assign(RoleLeader, "U3", now))
+	require.Nil(t, other.Save(found))
+	found, err = NewRedisStore(server.URL, "redis-token").Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, "U3", found.Leader)
+}
+
+func TestRedisStoreClose(t *testing.T) {
+	server, keys := fakeRedis(t)
+	store := NewRedisStore(server.URL, "redis-token")
+	now := time.Unix(1603980505, 0).UTC()
+	_, err := Open(store, "checkout is down", "C1", "U1", 1, now)
+	require.Nil(t, err)
+	closed, err := Close(store, "C1", "U1", now.Add(time.Hour))
+	require.Nil(t, err)
+	require.Equal(t, StatusResolved, closed.Status)
+	require.NotContains(t, keys, "nebo:fire:open:C1")
+
+	_, err = store.OpenIn("C1")
+	require.Equal(t, ErrNotFound, err)
+	found, err := store.Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, StatusResolved, found.Status)
+}
+
+func TestRedisStoreCreateClaimsTheChannel(t *testing.T) {
+	server, keys := fakeRedis(t)
+	store := NewRedisStore(server.URL, "redis-token")
+	// both fires checked the channel before either was created
+	first := &Incident{Title: "checkout is down", Channel: "C1", Status: StatusOpen}
+	second := &Incident{Title: "checkout is still down", Channel: "C1", Status: StatusOpen}
+	require.Nil(t, store.Create(first))
+	require.Equal(t, ErrAlreadyOpen, store.Create(second))
+	found, err := store.OpenIn("C1")
+	require.Nil(t, err)
+	require.Equal(t, "checkout is down", found.Title)
+	require.NotContains(t, keys, "nebo:fire:incident:"+second.ID)
+
+	keys["nebo:fire:open:C2"] = "FIRE-99"
+	left := &Incident{Title: "search is slow", Channel: "C2", Status: StatusOpen}
+	require.Nil(t, store.Create(left))
+	found, err = store.OpenIn("C2")
+	require.Nil(t, err)
+	require.Equal(t, left.ID, found.ID)
+}
+
+func TestRedisStoreNotFound(t *testing.T) {
+	server, _ := fakeRedis(t)
+	store := NewRedisStore(server.URL, "redis-token")
+	_, err := store.Get("FIRE-1")
+	require.Equal(t, ErrNotFound, err)
+	_, err = store.OpenIn("C1")
+	require.Equal(t, ErrNotFound, err)
+	require.Equal(t, ErrNotFound, store.Save(&Incident{ID: "FIRE-99", Channel: "C1"}))
+}
+
+func TestRedisStoreErrors(t *testing.T) {
+	server, _ := fakeRedis(t)
+	_, err := NewRedisStore(server.URL, "wrong-token").Get("FIRE-1")
+	require.EqualError(t, err, `redis GET returned 401: {"error":"Unauthorized"}`)
+	require.Nil(t, NewRedisStore("", "redis-token"))
+	require.Nil(t, NewRedisStore(server.URL, ""))
+}
@@ -0,0 +1,135 @@
+package incident
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/searchspring/nebo/atomicfile"
+)
+
+// Store keeps incidents between commands
+type Store interface {
+	// Create gives the incident an ID and saves it, or returns ErrAlreadyOpen if its channel already
+	// has an open incident
+	Create(i *Incident) error
+	// Save replaces the stored incident with the same ID
+	Save(i *Incident) error
+	// Get returns the incident with the ID, or ErrNotFound
+	Get(id string) (*Incident, error)
+	// OpenIn returns the open incident in the channel, or ErrNotFound
+	OpenIn(channel string) (*Incident, error)
+}
+
+// idPrefix starts every incident ID, the rest is a number counting up from 1
+const idPrefix = "FIRE-"
+
+// FileStore keeps incidents as JSON in a local file, read and written whole on every call. Each
+// serverless instance has its own files, so it is only fit for tests; deployments use RedisStore.
+type FileStore struct {
+	File string
+
+	mutex sync.Mutex
+}
+
+// fileContents is what is persisted to FileStore.File
+type fileContents struct {
+	LastID    int         `json:"lastId"`
+	Incidents []*Incident `json:"incidents"`
+}
+
+// NewFileStore returns a store that keeps incidents in the file, creating it on first write
+func NewFileStore(file string) *FileStore {
+	return &FileStore{File: file}
+}
+
+// Create gives the incident the next ID and saves it
+func (s *FileStore) Create(i *Incident) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	contents, err := s.read()
+	if err != nil {
+		return err
+	}
+	for _, stored := range contents.Incidents {
+		if stored.Channel == i.Channel && stored.Status == StatusOpen {
+			return ErrAlreadyOpen
+		}
+	}
+	contents.LastID++
+	i.ID = idPrefix + strconv.Itoa(contents.LastID)
+	contents.Incidents = append(contents.Incidents, i)
+	return s.write(contents)
+}
+
+// Save replaces the stored incident with the same ID
+func (s *FileStore) Save(i *Incident) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	contents, err := s.read()
+	if err != nil {
+		return err
+	}
+	for n, stored := range contents.Incidents {
+		if stored.ID == i.ID {
+			contents.Incidents[n] = i
+			return s.write(contents)
+		}
+	}
+	return ErrNotFound
+}
+
+// Get returns the incident with the ID
+func (s *FileStore) Get(id string) (*Incident, error) {
+	return s.find(func(i *Incident) bool { return i.ID == id })
+}
+
+// OpenIn returns the open incident in the channel
+func (s *FileStore) OpenIn(channel string) (*Incident, error) {
+	return s.find(func(i *Incident) bool { return i.Channel == channel && i.Status == StatusOpen })
+}
+
+// find returns the most recent incident the test accepts
+func (s *FileStore) find(test func(*Incident) bool) (*Incident, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	contents, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	for n := len(contents.Incidents) - 1; n >= 0; n-- {
+		if test(contents.Incidents[n]) {
+			return contents.Incidents[n], nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// read loads the file, which is empty if it does not exist yet. The caller holds the mutex.
+func (s *FileStore) read() (*fileContents, error) {
+	contents := &fileContents{}
+	body, err := ioutil.ReadFile(s.File)
+	if os.IsNotExist(err) {
+		return contents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(body, contents)
+	if err != nil {
+		return nil, err
+	}
+	return contents, nil
+}
+
+// write replaces the file in one step so a concurrent reader never sees half of it. The caller holds
+// the mutex.
+func (s *FileStore) write(contents *fileContents) error {
+	body, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	return atomicfile.Write(s.File, body)
+}
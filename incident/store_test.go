@@ -0,0 +1,67 @@
+package incident
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStoreSurvivesARestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "incidents.json")
+	now := time.Unix(1603980505, 0).UTC()
+	i := &Incident{Title: "checkout is down", Channel: "C1", Status: StatusOpen, Started: now, Leader: "U1"}
+	require.Nil(t, NewFileStore(file).Create(i))
+
+	restarted := NewFileStore(file)
+	found, err := restarted.OpenIn("C1")
+	require.Nil(t, err)
+	require.Equal(t, i, found)
+	found, err = restarted.Get("FIRE-1")
+	require.Nil(t, err)
+	require.Equal(t, "checkout is down", found.Title)
+
+	next := &Incident{Channel: "C2", Status: StatusOpen}
+	require.Nil(t, restarted.Create(next))
+	require.Equal(t, "FIRE-2", next.ID)
+}
+
+func TestFileStoreSave(t *testing.T) {
+	store := testStore(t)
+	i := &Incident{Channel: "C1", Status: StatusOpen}
+	require.Nil(t, store.Create(i))
+	i.Announcer = "U3"
+	require.Nil(t, store.Save(i))
+	found, err := store.Get(i.ID)
+	require.Nil(t, err)
+	require.Equal(t, "U3", found.Announcer)
+
+	require.Equal(t, ErrNotFound, store.Save(&Incident{ID: "FIRE-99"}))
+}
+
+func TestFileStoreCreateInAChannelWithAFire(t *testing.T) {
+	store := testStore(t)
+	require.Nil(t, store.Create(&Incident{Channel: "C1", Status: StatusOpen}))
+	require.Equal(t, ErrAlreadyOpen, store.Create(&Incident{Channel: "C1", Status: StatusOpen}))
+	_, err := Close(store, "C1", "U1", time.Unix(1603980505, 0))
+	require.Nil(t, err)
+	require.Nil(t, store.Create(&Incident{Channel: "C1", Status: StatusOpen}))
+}
+
+func TestFileStoreNotFound(t *testing.T) {
+	store := testStore(t)
+	_, err := store.Get("FIRE-1")
+	require.Equal(t, ErrNotFound, err)
+	_, err = store.OpenIn("C1")
+	require.Equal(t, ErrNotFound, err)
+}
+
+func TestFileStoreUnreadableFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "incidents.json")
+	require.Nil(t, ioutil.WriteFile(file, []byte("not json"), 0644))
+	_, err := NewFileStore(file).OpenIn("C1")
+	require.NotNil(t, err)
+	require.NotEqual(t, ErrNotFound, err)
+}
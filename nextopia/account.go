@@ -0,0 +1,105 @@
+package nextopia
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// accountColumns is how many columns each row of the client report account table has
+const accountColumns = 10
+
+// updatedLayout is the format of the last updated column, the client report leaves out the zone
+const updatedLayout = "2006-01-02 15:04:05"
+
+// Account is a row of the client report account table, e.g.
+// ["ee33869e9bdf9371963dca152444c212","6130a8c8e4e4543953af4118186b145f","ec_123djcom","ACTIVE","123dj.com","Professional","n\/a","unset","v1.5.1","2020-06-17 18:25:59"]
+type Account struct {
+	ID1           string    `json:"id1"`
+	ID2           string    `json:"id2"`
+	Name          string    `json:"name"`
+	Status        string    `json:"status"`
+	URL           string    `json:"url"`
+	Plan          string    `json:"plan"`
+	Other         string    `json:"other"`
+	SearchBackend string    `json:"searchBackend"`
+	Version       string    `json:"version"`
+	Updated       time.Time `json:"updated"`
+}
+
+// Active reports whether nextopia marks the account as active
+func (a *Account) Active() bool {
+	return a.Status == "ACTIVE"
+}
+
+// parseAccount reads a row of the table. An update time of all zeros means the account was never
+// updated and leaves Updated as the zero time.
+func parseAccount(row []string) (*Account, error) {
+	if len(row) != accountColumns {
+		return nil, fmt.Errorf("expected %d columns but found %d", accountColumns, len(row))
+	}
+	if row[0] == "" {
+		return nil, fmt.Errorf("missing ID 1")
+	}
+	account := &Account{
+		ID1:           row[0],
+		ID2:           row[1],
+		Name:          row[2],
+		Status:        row[3],
+		URL:           row[4],
+		Plan:          row[5],
+		Other:         row[6],
+		SearchBackend: row[7],
+		Version:       row[8],
+	}
+	if row[9] != "" && row[9] != "0000-00-00 00:00:00" {
+		updated, err := time.Parse(updatedLayout, row[9])
+		if err != nil {
+			return nil, fmt.Errorf("bad last updated time %q", row[9])
+		}
+		account.Updated = updated
+	}
+	return account, nil
+}
+
+// rowError describes a row of the table that could not be read
+type rowError struct {
+	Row int
+	Err error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("nextopia account row %d: %s", e.Row, e.Err.Error())
+}
+
+// accountTable is the client report response, each row is decoded on its own so that one bad row
+// does not lose the whole table
+type accountTable struct {
+	Data []json.RawMessage `json:"data"`
+}
+
+// decodeAccounts reads the table keyed by ID 1, returning the rows that could not be read alongside
+// the ones that could
+func decodeAccounts(body []byte) (map[string]*Account, []error, error) {
+	table := &accountTable{}
+	err := json.Unmarshal(body, table)
+	if err != nil {
+		return nil, nil, err
+	}
+	accounts := map[string]*Account{}
+	malformed := []error{}
+	for i, raw := range table.Data {
+		row := []string{}
+		err := json.Unmarshal(raw, &row)
+		if err == nil {
+			var account *Account
+			account, err = parseAccount(row)
+			if err == nil {
+				accounts[account.ID1] = account
+				continue
+			}
+		}
+		malformed = append(malformed, &rowError{Row: i, Err: err})
+	}
+	return accounts, malformed, nil
+}
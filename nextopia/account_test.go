@@ -0,0 +1,46 @@
+package nextopia
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeAccounts(t *testing.T) {
+	body := []byte(`{"result":"success","data":[
+		["50ae9d89c8d2879b028227bad4ad0220","54762cbb0dc2475aa35485a26c79cf41","","INACTIVE","","Trial","n\/a","32-bit","legacy","0000-00-00 00:00:00"],
+		["ee33869e9bdf9371963dca152444c212","6130a8c8e4e4543953af4118186b145f","ec_123djcom","ACTIVE","123dj.com","Professional","n\/a","unset","v1.5.1","2020-06-17 18:25:59"],
+		["c3f3888a9c554f58ccd420a6491284a4","cec4a2a2d6680cf83c3cf8685176e6c5","ec_short"],
+		["3502dc102d967598693d671cd0a82d68","7213b73fa377d8572ae0731e6aa0d3f1","ec_123healthshopcouk","INACTIVE","123healthshop.co.uk","Trial","n\/a","unset","v2.0","yesterday"],
+		["00b5a6084631611ae5ff7e6d037c7a1e",null,"ec_101inkscom","INACTIVE","101inks.com","Trial","n\/a","unset","legacy",7]
+	]}`)
+	accounts, malformed, err := decodeAccounts(body)
+	require.Nil(t, err)
+	require.Len(t, accounts, 2)
+	require.Equal(t, &Account{
+		ID1:           "ee33869e9bdf9371963dca152444c212",
+		ID2:           "6130a8c8e4e4543953af4118186b145f",
+		Name:          "ec_123djcom",
+		Status:        "ACTIVE",
+		URL:           "123dj.com",
+		Plan:          "Professional",
+		Other:         "n/a",
+		SearchBackend: "unset",
+		Version:       "v1.5.1",
+		Updated:       time.Date(2020, 6, 17, 18, 25, 59, 0, time.UTC),
+	}, accounts["ee33869e9bdf9371963dca152444c212"])
+	require.True(t, accounts["ee33869e9bdf9371963dca152444c212"].Active())
+	require.False(t, accounts["50ae9d89c8d2879b028227bad4ad0220"].Active())
+	require.True(t, accounts["50ae9d89c8d2879b028227bad4ad0220"].Updated.IsZero())
+
+	require.Len(t, malformed, 3)
+	require.EqualError(t, malformed[0], "nextopia account row 2: expected 10 columns but found 3")
+	require.EqualError(t, malformed[1], `nextopia account row 3: bad last updated time "yesterday"`)
+	require.Contains(t, malformed[2].Error(), "nextopia account row 4: ")
+}
+
+func TestDecodeAccountsRejectsOtherResponses(t *testing.T) {
+	_, _, err := decodeAccounts([]byte(`<html>Unauthorized</html>`))
+	require.NotNil(t, err)
+}
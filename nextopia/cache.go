@@ -0,0 +1,157 @@
+package nextopia
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/searchspring/nebo/atomicfile"
+)
+
+// DefaultCacheTTL is how long the account table is used before it is downloaded again
+const DefaultCacheTTL = time.Hour
+
+// Cache keeps the account table between invocations. Once the table is older than the TTL it is
+// still served while a fresh copy downloads in the background. If File is set the table is also
+// written there, so that a cold start can begin from the last copy instead of downloading it.
+type Cache struct {
+	TTL   time.Duration
+	File  string
+	Fetch func() (map[string]*Account, error)
+	Now   func() time.Time
+
+	mutex      sync.Mutex
+	customers  map[string]*Account
+	fetched    time.Time
+	refreshing bool
+	loaded     bool
+}
+
+// cacheFile is what is persisted to Cache.File
+type cacheFile struct {
+	Fetched   time.Time           `json:"fetched"`
+	Customers map[string]*Account `json:"customers"`
+}
+
+// NewCache returns a cache of the table downloaded by fetch
+func NewCache(fetch func() (map[string]*Account, error), ttl time.Duration, file string) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &Cache{
+		TTL:   ttl,
+		File:  file,
+		Fetch: fetch,
+		Now:   time.Now,
+	}
+}
+
+// Customers returns the account table keyed by ID 1, downloading it if there is no copy yet and
+// starting a background refresh if the copy is stale
+func (c *Cache) Customers() (map[string]*Account, error) {
+	c.mutex.Lock()
+	if !c.loaded {
+		c.loaded = true
+		c.readFile()
+	}
+	customers := c.customers
+	stale := c.Now().Sub(c.fetched) > c.TTL
+	startRefresh := customers != nil && stale && !c.refreshing
+	if startRefresh {
+		c.refreshing = true
+	}
+	c.mutex.Unlock()
+
+	if customers == nil {
+		_, err := c.Refresh()
+		if err != nil {
+			return nil, err
+		}
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.customers, nil
+	}
+	if startRefresh {
+		go func() {
+			_, err := c.refresh()
+			if err != nil {
+				log.Println("nextopia background refresh failed: " + err.Error())
+			}
+		}()
+	}
+	return customers, nil
+}
+
+// Refresh downloads the table now, returning how many accounts it holds
+func (c *Cache) Refresh() (int, error) {
+	c.mutex.Lock()
+	c.refreshing = true
+	c.mutex.Unlock()
+	return c.refresh()
+}
+
+// Age returns how long ago the table was downloaded, or false if it never has been
+func (c *Cache) Age() (time.Duration, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.customers == nil {
+		return 0, false
+	}
+	return c.Now().Sub(c.fetched), true
+}
+
+func (c *Cache) refresh() (int, error) {
+	customers, err := c.Fetch()
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.refreshing = false
+	if err != nil {
+		return 0, err
+	}
+	c.customers = customers
+	c.fetched = c.Now()
+	c.writeFile()
+	return len(customers), nil
+}
+
+// readFile loads the persisted table, if there is one. The caller holds the mutex.
+func (c *Cache) readFile() {
+	if c.File == "" {
+		return
+	}
+	body, err := ioutil.ReadFile(c.File)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("reading nextopia cache: " + err.Error())
+		}
+		return
+	}
+	saved := &cacheFile{}
+	err = json.Unmarshal(body, saved)
+	if err != nil || saved.Customers == nil {
+		log.Println("ignoring unreadable nextopia cache " + c.File)
+		return
+	}
+	c.customers = saved.Customers
+	c.fetched = saved.Fetched
+}
+
+// writeFile persists the table, replacing the old file in one step so a concurrent reader never
+// sees half of it. The caller holds the mutex.
+func (c *Cache) writeFile() {
+	if c.File == "" {
+		return
+	}
+	body, err := json.Marshal(&cacheFile{Fetched: c.fetched, Customers: c.customers})
+	if err != nil {
+		log.Println("writing nextopia cache: " + err.Error())
+		return
+	}
+	err = atomicfile.Write(c.File, body)
+	if err != nil {
+		log.Println("writing nextopia cache: " + err.Error())
+	}
+}
@@ -0,0 +1,86 @@
+package nextopia
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// countingFetch returns a fetch that hands out the customers and counts how often it was called
+func countingFetch(customers map[string]*Account, err error) (func() (map[string]*Account, error), *int) {
+	count := 0
+	return func() (map[string]*Account, error) {
+		count++
+		return customers, err
+	}, &count
+}
+
+func TestCacheFetchesOnceWithinTTL(t *testing.T) {
+	fetch, count := countingFetch(createCustomers(3), nil)
+	cache := NewCache(fetch, time.Hour, "")
+	for i := 0; i < 3; i++ {
+		customers, err := cache.Customers()
+		require.Nil(t, err)
+		require.Len(t, customers, 3)
+	}
+	require.Equal(t, 1, *count)
+}
+
+func TestCacheServesStaleTableWhileRefreshing(t *testing.T) {
+	now := time.Unix(1603980505, 0)
+	done := make(chan bool)
+	calls := 0
+	cache := NewCache(func() (map[string]*Account, error) {
+		calls++
+		if calls == 1 {
+			return createCustomers(3), nil
+		}
+		defer close(done)
+		return createCustomers(5), nil
+	}, time.Hour, "")
+	cache.Now = func() time.Time { return now }
+	_, err := cache.Customers()
+	require.Nil(t, err)
+
+	now = now.Add(2 * time.Hour)
+	customers, err := cache.Customers()
+	require.Nil(t, err)
+	require.Len(t, customers, 3)
+	<-done
+	customers, err = cache.Customers()
+	require.Nil(t, err)
+	require.Len(t, customers, 5)
+}
+
+func TestCacheReturnsFetchErrors(t *testing.T) {
+	fetch, _ := countingFetch(nil, errors.New("nextopia is down"))
+	_, err := NewCache(fetch, time.Hour, "").Customers()
+	require.EqualError(t, err, "nextopia is down")
+}
+
+func TestCacheRefresh(t *testing.T) {
+	fetch, count := countingFetch(createCustomers(4), nil)
+	cache := NewCache(fetch, time.Hour, "")
+	_, err := cache.Customers()
+	require.Nil(t, err)
+	n, err := cache.Refresh()
+	require.Nil(t, err)
+	require.Equal(t, 4, n)
+	require.Equal(t, 2, *count)
+}
+
+func TestCachePersistsToFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "nextopia.json")
+	fetch, _ := countingFetch(createCustomers(2), nil)
+	_, err := NewCache(fetch, time.Hour, file).Customers()
+	require.Nil(t, err)
+
+	coldFetch, count := countingFetch(nil, errors.New("should not be called"))
+	customers, err := NewCache(coldFetch, time.Hour, file).Customers()
+	require.Nil(t, err)
+	require.Len(t, customers, 2)
+	require.Equal(t, 0, *count)
+}
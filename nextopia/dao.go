@@ -2,118 +2,305 @@ package nextopia
 
 import (
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"log"
 	"net/http"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/paging"
+	"github.com/searchspring/nebo/render"
 	"github.com/searchspring/nebo/validator"
 )
 
 // DAO acts as the nextopia DAO
 type DAO interface {
-	Query(query string) ([]byte, error)
+	Query(query string, offset int) ([]byte, error)
+	Refresh() ([]byte, error)
+	Accounts(query string) ([]*Account, error)
 }
 
+// DefaultURL is the nextopia client report
+const DefaultURL = "https://client-report.nxtpd.com"
+
+const accountsPath = "/api/data-table.php?table=accounts"
+
+// PageBlockID identifies the paging buttons on /neboidnx results
+const PageBlockID = "nextopia_page"
+
 // DAOImpl defines the properties of the DAO
 type DAOImpl struct {
-	Client    *http.Client
-	User      string
-	Password  string
-	Customers map[string][]string
+	Client   *http.Client
+	URL      string
+	User     string
+	Password string
+	Cache    *Cache
 }
 
-// NewDAO returns the nextopia DAO
-func NewDAO(nxUser string, nxPassword string) DAO {
+// NewDAO returns the nextopia DAO for the client report at nxURL, or DefaultURL if it is blank. The
+// account table is kept for cacheTTL, and in cacheFile if it is not blank.
+func NewDAO(nxURL string, nxUser string, nxPassword string, cacheTTL time.Duration, cacheFile string) DAO {
 	if validator.ContainsEmptyString(nxUser, nxPassword) {
 		return nil
 	}
-	return &DAOImpl{
+	if nxURL == "" {
+		nxURL = DefaultURL
+	}
+	dao := &DAOImpl{
+		Client:   httpclient.New("Nextopia", 20*time.Second),
+		URL:      nxURL,
 		User:     nxUser,
 		Password: nxPassword,
-		Client:   http.DefaultClient,
 	}
+	dao.Cache = NewCache(dao.fetch, cacheTTL, cacheFile)
+	return dao
+}
+
+// Query queries the nextopia client report DB using provided query string, showing a page of
+// matches starting at offset
+func (d *DAOImpl) Query(query string, offset int) ([]byte, error) {
+	customers, err := d.Cache.Customers()
+	if err != nil {
+		return nil, err
+	}
+	msg := findMatch(customers, query, offset, time.Now())
+	return json.Marshal(msg)
+}
+
+// Accounts returns the active accounts that match the search, best first. Close names are not
+// suggested, only accounts that really match are returned.
+func (d *DAOImpl) Accounts(query string) ([]*Account, error) {
+	customers, err := d.Cache.Customers()
+	if err != nil {
+		return nil, err
+	}
+	found, _, fuzzy := rankMatches(customers, query, false)
+	if fuzzy {
+		return []*Account{}, nil
+	}
+	return found, nil
+}
+
+// Refresh downloads the account table now rather than waiting for the cache to expire
+func (d *DAOImpl) Refresh() ([]byte, error) {
+	count, err := d.Cache.Refresh()
+	if err != nil {
+		return nil, err
+	}
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         fmt.Sprintf("Refreshed the Nextopia account table, %d accounts.", count),
+	}
+	return json.Marshal(msg)
 }
 
-// {"result":"success","data":[["50ae9d89c8d2879b028227bad4ad0220","54762cbb0dc2475aa35485a26c79cf41","","INACTIVE","","Trial","n\/a","32-bit","legacy","0000-00-00 00:00:00"],["00b5a6084631611ae5ff7e6d037c7a1e","b913c134faf624e8e26b2f841a346352","ec_101inkscom","INACTIVE","101inks.com","Trial","n\/a","unset","legacy","2015-10-07 14:23:28"],["ee33869e9bdf9371963dca152444c212","6130a8c8e4e4543953af4118186b145f","ec_123djcom","ACTIVE","123dj.com","Professional","n\/a","unset","v1.5.1","2020-06-17 18:25:59"],["3502dc102d967598693d671cd0a82d68","7213b73fa377d8572ae0731e6aa0d3f1","ec_123healthshopcouk","INACTIVE","123healthshop.co.uk","Trial","n\/a","unset","v2.0","2018-06-16 10:37:52"],["c3f3888a9c554f58ccd420a6491284a4","cec4a2a2d6680cf83c3cf8685176e6c5","ec_123securityproductscom","ACTIVE","123securityproducts.com","Professional","n\/a","watson","v2.0","2020-06-11 14:19:40"],
-type resultData struct {
-	Data [][]string `json:"data"`
+// fetch downloads the account table from the client report, keyed by ID 1. Rows that cannot be
+// read are logged and left out.
+func (d *DAOImpl) fetch() (map[string]*Account, error) {
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(d.URL, "/")+accountsPath, nil)
+	if err != nil {
+		return nil, d.scrub(err)
+	}
+	req.SetBasicAuth(d.User, d.Password)
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return nil, d.scrub(err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, d.scrub(err)
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("nextopia returned %d", res.StatusCode)
+	}
+	customers, malformed, err := decodeAccounts(body)
+	if err != nil {
+		return nil, err
+	}
+	for _, err := range malformed {
+		log.Println(err.Error())
+	}
+	if len(malformed) > 0 {
+		log.Printf("skipped %d malformed nextopia account rows", len(malformed))
+	}
+	return customers, nil
 }
 
-// Query queries the nextopia client report DB using provided query string
-func (d *DAOImpl) Query(query string) ([]byte, error) {
-	if d.Customers == nil {
-		res, err := d.Client.Get("http://" + d.User + ":" + d.Password + "@client-report.nxtpd.com/api/data-table.php?table=accounts&_=1592606239141")
-		if err != nil {
-			return nil, err
+// FlagHelp lists the flags /neboidnx understands
+const FlagHelp = "`--active` (the default) or `--all` to include inactive accounts"
+
+// parseQuery splits the flags from the search. Only active accounts are shown unless --all is given.
+func parseQuery(query string) (string, bool, error) {
+	words := []string{}
+	all := false
+	for _, word := range strings.Fields(query) {
+		switch {
+		case word == "--all":
+			all = true
+		case word == "--active":
+			all = false
+		case strings.HasPrefix(word, "--"):
+			return "", false, fmt.Errorf("don't know the flag `%s`", word)
+		default:
+			words = append(words, word)
 		}
-		body, err := ioutil.ReadAll(res.Body)
-		if err != nil {
-			return nil, err
+	}
+	return strings.Join(words, " "), all, nil
+}
+
+// scrub removes the credentials from an error so they never reach the logs or slack
+func (d *DAOImpl) scrub(err error) error {
+	text := err.Error()
+	for _, secret := range []string{d.Password, d.User} {
+		if secret != "" {
+			text = strings.ReplaceAll(text, secret, "***")
 		}
-		resultData := &resultData{}
+	}
+	return &scrubbedError{text: text, err: err}
+}
+
+// scrubbedError keeps the error it scrubbed so callers can still tell what kind of failure it was
+type scrubbedError struct {
+	text string
+	err  error
+}
+
+func (e *scrubbedError) Error() string {
+	return e.text
+}
 
-		err = json.Unmarshal(body, resultData)
-		if err != nil {
-			return nil, err
+func (e *scrubbedError) Unwrap() error {
+	return e.err
+}
+
+func findMatch(customers map[string]*Account, query string, offset int, now time.Time) *slack.Msg {
+	search, all, err := parseQuery(query)
+	if err != nil {
+		return &slack.Msg{
+			ResponseType: slack.ResponseTypeEphemeral,
+			Text:         "Sorry, I " + err.Error() + ", use " + FlagHelp,
 		}
-		d.Customers = map[string][]string{}
-		for _, row := range resultData.Data {
-			d.Customers[row[0]] = row
+	}
+	found, inactive, fuzzy := rankMatches(customers, search, all)
+	if len(found) == 0 {
+		text := "No Matches :("
+		if inactive > 0 {
+			text = fmt.Sprintf("No active matches, %d inactive. Add `--all` to see them.", inactive)
 		}
+		return render.Message(text, nil, nil)
 	}
-	msg := d.findMatch(query)
-	return json.Marshal(msg)
+	start, end := paging.Bounds(offset, len(found))
+	accounts := []*render.Account{}
+	for _, account := range found[start:end] {
+		accounts = append(accounts, renderCustomer(account, now))
+	}
+	text := "matches"
+	if fuzzy {
+		text = "No exact matches for `" + search + "`. Did you mean…"
+	}
+	return render.Message(text, accounts, paging.Blocks(PageBlockID, query, start, len(found)))
 }
 
-const ID1 = 0
-const ID2 = 1
-const NAME = 2
-const URL = 4
-const TYPE = 5
-const VERSION = 7
-const SYSTEM = 8
-
-func (d *DAOImpl) findMatch(query string) *slack.Msg {
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeInChannel,
-		Text:         "matches",
-		Attachments:  []slack.Attachment{},
-	}
-	for _, value := range d.Customers {
-		if matches(value, query) {
-			color := "3A23AD" // Searchspring purple
-			text := "URL: " + value[URL] +
-				"\nID 1: " + value[ID1] +
-				"\nID 2: " + value[ID2] +
-				"\nType: " + value[TYPE] +
-				"\nVersion: " + value[VERSION] + ", System: " + value[SYSTEM]
-
-			msg.Attachments = append(msg.Attachments, slack.Attachment{
-				Color:      "#" + color,
-				Text:       text,
-				AuthorName: value[NAME],
-			})
+// rankMatches returns the accounts that match the search, best first, along with how many inactive
+// accounts matched but were left out. If nothing matches the search exactly the accounts with names
+// close to it are returned instead, and fuzzy is true.
+func rankMatches(customers map[string]*Account, search string, all bool) (found []*Account, inactive int, fuzzy bool) {
+	score := map[*Account]int{}
+	search = strings.ToLower(strings.TrimSpace(search))
+	for _, account := range customers {
+		q := match(account, search)
+		if q == noMatch {
+			continue
+		}
+		if !all && !account.Active() {
+			inactive++
+			continue
 		}
-		if len(msg.Attachments) > 100 {
-			break
+		found = append(found, account)
+		score[account] = int(q)
+	}
+	if len(found) == 0 && inactive == 0 {
+		fuzzy = true
+		for _, account := range customers {
+			distance, ok := fuzzyMatch(account, search)
+			if !ok || (!all && !account.Active()) {
+				continue
+			}
+			found = append(found, account)
+			score[account] = -distance
 		}
 	}
-	if len(msg.Attachments) == 0 {
-		msg.Text = "No Matches :("
+	sortAccounts(found, score)
+	if found == nil {
+		found = []*Account{}
 	}
-	return msg
+	return found, inactive, fuzzy
 }
 
-func matches(customer []string, query string) bool {
-	if strings.HasPrefix(customer[ID1], query) {
-		return true
+// sortAccounts puts the best scoring matches first, then active accounts, then the most recently
+// updated. Name and ID 1 break any remaining ties so the order is the same every time.
+func sortAccounts(accounts []*Account, score map[*Account]int) {
+	sort.Slice(accounts, func(i, j int) bool {
+		a, b := accounts[i], accounts[j]
+		if score[a] != score[b] {
+			return score[a] > score[b]
+		}
+		if a.Active() != b.Active() {
+			return a.Active()
+		}
+		if !a.Updated.Equal(b.Updated) {
+			return a.Updated.After(b.Updated)
+		}
+		if a.Name != b.Name {
+			return a.Name < b.Name
+		}
+		return a.ID1 < b.ID1
+	})
+}
+
+func renderCustomer(customer *Account, now time.Time) *render.Account {
+	status := "Inactive"
+	if customer.Active() {
+		status = "Active"
+	}
+	account := &render.Account{
+		Title:  customer.Name,
+		Status: status,
+		Fields: []render.Field{
+			{Label: "URL", Value: customer.URL},
+			{Label: "ID 1", Value: customer.ID1},
+			{Label: "ID 2", Value: customer.ID2},
+			{Label: "Plan", Value: customer.Plan},
+			{Label: "Search backend", Value: customer.SearchBackend},
+			{Label: "Version", Value: customer.Version},
+		},
+		Context: []string{lastUpdated(customer.Updated, now)},
+		ID:      customer.ID1,
 	}
-	if strings.HasPrefix(customer[ID2], query) {
-		return true
+	if customer.URL != "" {
+		account.Link = "https://" + customer.URL
+		account.LinkText = "Open website"
+	}
+	return account
+}
+
+// lastUpdated describes how long ago the account was updated in whole days
+func lastUpdated(updated time.Time, now time.Time) string {
+	if updated.IsZero() {
+		return "never updated"
 	}
-	if strings.Contains(customer[NAME], query) {
-		return true
+	days := int(now.Sub(updated).Hours() / 24)
+	switch {
+	case days <= 0:
+		return "last updated today"
+	case days == 1:
+		return "last updated 1 day ago"
+	default:
+		return fmt.Sprintf("last updated %d days ago", days)
 	}
-	return false
 }
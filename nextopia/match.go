@@ -0,0 +1,111 @@
+package nextopia
+
+import (
+	"strings"
+	"unicode"
+)
+
+// matchQuality ranks how well an account matches a search, better matches are larger
+type matchQuality int
+
+const (
+	noMatch matchQuality = iota
+	nameContains
+	nameExact
+	idPrefix
+	idExact
+)
+
+// match finds the best way the account matches the lower case search. IDs are compared ignoring
+// case, names after normalizing both sides.
+func match(customer *Account, query string) matchQuality {
+	if query == "" {
+		return noMatch
+	}
+	id1 := strings.ToLower(customer.ID1)
+	id2 := strings.ToLower(customer.ID2)
+	name := normalize(customer.Name)
+	search := normalize(query)
+	switch {
+	case id1 == query || id2 == query:
+		return idExact
+	case strings.HasPrefix(id1, query) || strings.HasPrefix(id2, query):
+		return idPrefix
+	case search == "":
+		return noMatch
+	case name == search:
+		return nameExact
+	case strings.Contains(name, search):
+		return nameContains
+	}
+	return noMatch
+}
+
+// normalize reduces a name or search to lower case letters and digits, so that "ec_shoescom",
+// "Shoes.com" and "www.shoes.com" all become "shoescom"
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimPrefix(name, "ec_")
+	name = strings.TrimPrefix(name, "https://")
+	name = strings.TrimPrefix(name, "http://")
+	name = strings.TrimPrefix(name, "www.")
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return r
+		}
+		return -1
+	}, name)
+}
+
+// minFuzzyLength is the shortest search that is matched fuzzily, shorter ones match too much
+const minFuzzyLength = 4
+
+// fuzzyMatch finds how many typos apart the search is from the closest part of the account name.
+// A search may have one typo for every four characters.
+func fuzzyMatch(customer *Account, query string) (int, bool) {
+	search := normalize(query)
+	if len([]rune(search)) < minFuzzyLength {
+		return 0, false
+	}
+	distance := substringDistance(search, normalize(customer.Name))
+	return distance, distance <= len([]rune(search))/4
+}
+
+// substringDistance is the edit distance between the pattern and the substring of text that is
+// closest to it, so a pattern that appears in text exactly has a distance of 0
+func substringDistance(pattern string, text string) int {
+	p := []rune(pattern)
+	t := []rune(text)
+	// previous[j] is the distance of the pattern so far to some substring of text ending at j. Row 0
+	// is all zeros because the match may start anywhere in text.
+	previous := make([]int, len(t)+1)
+	current := make([]int, len(t)+1)
+	for i := 1; i <= len(p); i++ {
+		current[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if p[i-1] == t[j-1] {
+				cost = 0
+			}
+			current[j] = min3(previous[j-1]+cost, previous[j]+1, current[j-1]+1)
+		}
+		previous, current = current, previous
+	}
+	best := len(p)
+	for _, d := range previous {
+		if d < best {
+			best = d
+		}
+	}
+	return best
+}
+
+func min3(a int, b int, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,66 @@
+package nextopia
+
+import (
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, name := range []string{"ec_shoescom", "Shoes.com", "www.shoes.com", "https://www.Shoes.com", " EC_SHOESCOM "} {
+		require.Equal(t, "shoescom", normalize(name), name)
+	}
+	require.Equal(t, "redwingshoescouk", normalize("red-wing-shoes.co.uk"))
+}
+
+func TestMatchIgnoresCaseAndPunctuation(t *testing.T) {
+	account := &Account{ID1: "A21BCDE5FE33aa", ID2: "b913c134", Name: "ec_shoescom"}
+	tests := []struct {
+		search  string
+		quality matchQuality
+	}{
+		{"a21bcde5fe33aa", idExact},
+		{"a21bcde5", idPrefix},
+		{"shoes.com", nameExact},
+		{"shoes", nameContains},
+		{"ec_shoes", nameContains},
+		{"boots", noMatch},
+		{"_.", noMatch},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.quality, match(account, test.search), test.search)
+	}
+}
+
+func TestSubstringDistance(t *testing.T) {
+	require.Equal(t, 0, substringDistance("shoes", "shoescom"))
+	require.Equal(t, 1, substringDistance("shoos", "shoescom"))
+	require.Equal(t, 1, substringDistance("shos", "redshoescom"))
+	require.Equal(t, 2, substringDistance("sheos", "shoescom"))
+	require.Equal(t, 5, substringDistance("boots", ""))
+}
+
+func TestFindMatchCaseInsensitive(t *testing.T) {
+	msg := findMatch(createCustomers(3), "EC_Shoes001.com", 0, testNow)
+	require.Equal(t, "matches", msg.Text)
+	require.Len(t, msg.Blocks.BlockSet, 1+2+1)
+	require.Equal(t, "*ec_shoes001com* (Active)", sectionText(t, msg.Blocks.BlockSet[1]))
+}
+
+func TestFindMatchSuggestsCloseNames(t *testing.T) {
+	customers := createCustomers(3)
+	customers["00000000000000000000000000000002"].Name = "ec_bootscom"
+	msg := findMatch(customers, "shoos", 0, testNow)
+	require.Equal(t, "No exact matches for `shoos`. Did you mean…", msg.Text)
+	require.Len(t, msg.Blocks.BlockSet, 1+2*2+1)
+	require.Equal(t, "*ec_shoes000com* (Active)", sectionText(t, msg.Blocks.BlockSet[1]))
+	require.Equal(t, "*ec_shoes001com* (Active)", sectionText(t, msg.Blocks.BlockSet[3]))
+
+	msg = findMatch(customers, "sandals", 0, testNow)
+	require.Equal(t, "No Matches :(", msg.Text)
+}
+
+func sectionText(t *testing.T, block slack.Block) string {
+	return decode(t, &slack.Msg{Blocks: slack.Blocks{BlockSet: []slack.Block{block}}}).Blocks.BlockSet[0].(*slack.SectionBlock).Text.Text
+}
@@ -0,0 +1,81 @@
+package paging
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nlopes/slack"
+)
+
+// Size is the number of results shown in one message
+const Size = 20
+
+// Page is stored in the Previous and Next buttons so the search can be run again at the right offset
+type Page struct {
+	Search string `json:"q"`
+	Offset int    `json:"o"`
+}
+
+// Bounds returns the start and end of the page that begins at offset, clamped to the results
+func Bounds(offset int, total int) (int, int) {
+	if offset < 0 || offset >= total {
+		offset = 0
+	}
+	end := offset + Size
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+// Summary describes the page, e.g. "showing 1–20 of 143"
+func Summary(offset int, total int) string {
+	start, end := Bounds(offset, total)
+	if total == 0 {
+		return "showing 0 of 0"
+	}
+	return fmt.Sprintf("showing %d–%d of %d", start+1, end, total)
+}
+
+// Blocks returns a context line with the summary and, when the results span more than one page,
+// Previous and Next buttons in a block identified by blockID. The interactivity endpoint uses the
+// block ID to decide which search to run again. No buttons are added when blockID is blank.
+func Blocks(blockID string, search string, offset int, total int) []slack.Block {
+	blocks := []slack.Block{
+		slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, Summary(offset, total), false, false)),
+	}
+	if blockID == "" || total <= Size {
+		return blocks
+	}
+	start, end := Bounds(offset, total)
+	buttons := []slack.BlockElement{}
+	if start > 0 {
+		previous := start - Size
+		if previous < 0 {
+			previous = 0
+		}
+		buttons = append(buttons, button("previous", "Previous", search, previous))
+	}
+	if end < total {
+		buttons = append(buttons, button("next", "Next", search, end))
+	}
+	return append(blocks, slack.NewActionBlock(blockID, buttons...))
+}
+
+func button(actionID string, label string, search string, offset int) *slack.ButtonBlockElement {
+	value, _ := json.Marshal(&Page{Search: search, Offset: offset})
+	return slack.NewButtonBlockElement(actionID, string(value), slack.NewTextBlockObject(slack.PlainTextType, label, false, false))
+}
+
+// Parse reads the page stored in a button value
+func Parse(value string) (*Page, error) {
+	page := &Page{}
+	err := json.Unmarshal([]byte(value), page)
+	if err != nil {
+		return nil, err
+	}
+	if page.Offset < 0 {
+		page.Offset = 0
+	}
+	return page, nil
+}
@@ -0,0 +1,56 @@
+package paging
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSummary(t *testing.T) {
+	require.Equal(t, "showing 1–20 of 143", Summary(0, 143))
+	require.Equal(t, "showing 141–143 of 143", Summary(140, 143))
+	require.Equal(t, "showing 1–5 of 5", Summary(0, 5))
+	require.Equal(t, "showing 1–20 of 143", Summary(500, 143))
+	require.Equal(t, "showing 0 of 0", Summary(0, 0))
+}
+
+func buttons(t *testing.T, blocks []slack.Block) map[string]*Page {
+	pages := map[string]*Page{}
+	for _, block := range blocks {
+		if action, ok := block.(*slack.ActionBlock); ok {
+			require.Equal(t, "salesforce_page", action.BlockID)
+			for _, element := range action.Elements.ElementSet {
+				button := element.(*slack.ButtonBlockElement)
+				page, err := Parse(button.Value)
+				require.Nil(t, err)
+				pages[button.ActionID] = page
+			}
+		}
+	}
+	return pages
+}
+
+func TestBlocks(t *testing.T) {
+	first := buttons(t, Blocks("salesforce_page", `rep:"Ashley Hilton"`, 0, 143))
+	require.Equal(t, map[string]*Page{"next": {`rep:"Ashley Hilton"`, 20}}, first)
+
+	middle := buttons(t, Blocks("salesforce_page", "shoes", 20, 143))
+	require.Equal(t, map[string]*Page{"previous": {"shoes", 0}, "next": {"shoes", 40}}, middle)
+
+	last := buttons(t, Blocks("salesforce_page", "shoes", 140, 143))
+	require.Equal(t, map[string]*Page{"previous": {"shoes", 120}}, last)
+
+	require.Len(t, Blocks("salesforce_page", "shoes", 0, 20), 1)
+	require.Len(t, Blocks("", "shoes", 0, 143), 1)
+}
+
+func TestBlocksMarshal(t *testing.T) {
+	msg := &slack.Msg{Blocks: slack.Blocks{BlockSet: Blocks("nextopia_page", "shoes", 0, 143)}}
+	body, err := json.Marshal(msg)
+	require.Nil(t, err)
+	decoded := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(body, decoded))
+	require.Len(t, decoded.Blocks.BlockSet, 2)
+}
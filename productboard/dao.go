@@ -1,83 +1,156 @@
 package productboard
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
-	"log"
-	"regexp"
-	"sort"
+	"io/ioutil"
+	"net/http"
 	"strings"
+	"time"
 
 	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/httpclient"
 	"github.com/searchspring/nebo/validator"
 )
 
+// DefaultURL is the productboard public API
+const DefaultURL = "https://api.productboard.com"
+
+const maxTitleLength = 80
+
 // DAO acts as the productboard DAO
 type DAO interface {
-	CreateNote(query string) ([]byte, error)
+	CreateNote(note *Note) ([]byte, error)
 }
 
 // DAOImpl defines the properties of the DAO
 type DAOImpl struct {
-	Client    *http.Client
-	// User      string
-	// Password  string
-	// Customers map[string][]string
+	Client *http.Client
+	URL    string
+	Token  string
+}
+
+// Note is a feature request to be filed in productboard
+type Note struct {
+	Content   string
+	Requester string
+	Email     string
+	SourceURL string
+	SourceID  string
+	Tags      []string
 }
 
 // NewDAO returns the productboard DAO
-func NewDAO() DAO {
-	if validator.ContainsEmptyString(nxUser, nxPassword) {
+func NewDAO(pbToken string) DAO {
+	if validator.ContainsEmptyString(pbToken) {
 		return nil
 	}
 	return &DAOImpl{
-		// User:     nxUser,
-		// Password: nxPassword,
-		Client:   http.DefaultClient,
+		Client: httpclient.New("Productboard", 10*time.Second),
+		URL:    DefaultURL,
+		Token:  pbToken,
 	}
 }
 
-// <Example JSON response here>
-type resultData struct {
-	Data [][]string `json:"data"`
+// https://developer.productboard.com/#operation/postNote
+type noteRequest struct {
+	Title         string      `json:"title"`
+	Content       string      `json:"content"`
+	CustomerEmail string      `json:"customer_email,omitempty"`
+	DisplayURL    string      `json:"display_url,omitempty"`
+	Source        *noteSource `json:"source,omitempty"`
+	Tags          []string    `json:"tags,omitempty"`
 }
 
-// <DESCRIPTION>
-func (d *DAOImpl) CreateNote(content string) ([]byte, error) {
-	// if d.Customers == nil {
-	// 	res, err := d.Client.Get("http://" + d.User + ":" + d.Password + "@client-report.nxtpd.com/api/data-table.php?table=accounts&_=1592606239141")
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	body, err := ioutil.ReadAll(res.Body)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	resultData := &resultData{}
+type noteSource struct {
+	Origin   string `json:"origin"`
+	RecordID string `json:"record_id"`
+}
 
-	// 	err = json.Unmarshal(body, resultData)
-	// 	if err != nil {
-	// 		return nil, err
-	// 	}
-	// 	d.Customers = map[string][]string{}
-	// 	for _, row := range resultData.Data {
-	// 		d.Customers[row[0]] = row
-	// 	}
-	// }
-	// msg := d.findMatch(query)
-	// return json.Marshal(msg)
+// {"links":{"html":"https://searchspring.productboard.com/inbox/notes/1234"},"data":{"id":"5e0c4c30-2a0f-4a2e-a1a6-3c5f1c1f3c4b"}}
+type noteResponse struct {
+	Links struct {
+		HTML string `json:"html"`
+	} `json:"links"`
+}
 
+// CreateNote files the note in the productboard insights inbox
+func (d *DAOImpl) CreateNote(note *Note) ([]byte, error) {
+	request := &noteRequest{
+		Title:         noteTitle(note.Content),
+		Content:       noteContent(note),
+		CustomerEmail: note.Email,
+		DisplayURL:    note.SourceURL,
+		Tags:          note.Tags,
+	}
+	if note.SourceID != "" {
+		request.Source = &noteSource{
+			Origin:   "slack",
+			RecordID: note.SourceID,
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, d.URL+"/notes", bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+d.Token)
+	req.Header.Set("X-Version", "1")
+	res, err := d.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusCreated && res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("productboard returned %d: %s", res.StatusCode, string(resBody))
+	}
+	noteResponse := &noteResponse{}
+	err = json.Unmarshal(resBody, noteResponse)
+	if err != nil {
+		return nil, err
+	}
+	return featureResponse(noteResponse.Links.HTML)
+}
 
+func noteTitle(content string) string {
+	title := strings.TrimSpace(content)
+	if i := strings.Index(title, "\n"); i != -1 {
+		title = title[:i]
+	}
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = strings.TrimSpace(string(runes[:maxTitleLength])) + "..."
+	}
+	return title
+}
 
-	return featureResponse(content)
+func noteContent(note *Note) string {
+	content := note.Content
+	if note.Requester != "" {
+		content += "\n\nRequested by " + note.Requester + " via Nebo"
+	}
+	if note.SourceURL != "" {
+		content += "\n" + note.SourceURL
+	}
+	return content
 }
 
-func featureResponse(content string) []byte {
+func featureResponse(link string) ([]byte, error) {
+	text := "Feature request submitted! The Product team will be in touch."
+	if link != "" {
+		text += " <" + link + "|View in Productboard>"
+	}
 	msg := &slack.Msg{
 		ResponseType: slack.ResponseTypeEphemeral,
-		Text:         "Feature request submitted! The Product team will be in touch.",
+		Text:         text,
 	}
-	json, _ := json.Marshal(msg)
-	return json
+	return json.Marshal(msg)
 }
-
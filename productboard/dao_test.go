@@ -0,0 +1,75 @@
+package productboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCreateNote(t *testing.T) {
+	var received map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/notes", r.URL.Path)
+		require.Equal(t, "Bearer pb-token", r.Header.Get("Authorization"))
+		require.Equal(t, "1", r.Header.Get("X-Version"))
+		body, _ := ioutil.ReadAll(r.Body)
+		require.Nil(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"links":{"html":"https://searchspring.productboard.com/inbox/notes/1234"},"data":{"id":"1234"}}`))
+	}))
+	defer server.Close()
+
+	dao := &DAOImpl{Client: server.Client(), URL: server.URL, Token: "pb-token"}
+	response, err := dao.CreateNote(&Note{
+		Content:   "export reports as csv\nso finance can use them",
+		Requester: "Ashley Hilton",
+		Email:     "ashley@searchspring.com",
+		SourceURL: "https://searchspring.slack.com/archives/G013YLWL3EX/p1603980505000100",
+		SourceID:  "1603980505.000100",
+		Tags:      []string{"nebo"},
+	})
+	require.Nil(t, err)
+
+	require.Equal(t, "export reports as csv", received["title"])
+	require.True(t, strings.HasPrefix(received["content"].(string), "export reports as csv\nso finance can use them"))
+	require.True(t, strings.Contains(received["content"].(string), "Requested by Ashley Hilton"))
+	require.Equal(t, "ashley@searchspring.com", received["customer_email"])
+	require.Equal(t, "https://searchspring.slack.com/archives/G013YLWL3EX/p1603980505000100", received["display_url"])
+	require.Equal(t, map[string]interface{}{"origin": "slack", "record_id": "1603980505.000100"}, received["source"])
+	require.Equal(t, []interface{}{"nebo"}, received["tags"])
+
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.True(t, strings.Contains(msg.Text, "https://searchspring.productboard.com/inbox/notes/1234"))
+}
+
+func TestCreateNoteError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		w.Write([]byte(`{"errors":[{"detail":"invalid token"}]}`))
+	}))
+	defer server.Close()
+
+	dao := &DAOImpl{Client: server.Client(), URL: server.URL, Token: "bad-token"}
+	_, err := dao.CreateNote(&Note{Content: "dark mode"})
+	require.NotNil(t, err)
+	require.True(t, strings.Contains(err.Error(), "401"))
+}
+
+func TestNoteTitle(t *testing.T) {
+	require.Equal(t, "dark mode", noteTitle("  dark mode  "))
+	require.Equal(t, strings.Repeat("a", 80)+"...", noteTitle(strings.Repeat("a", 100)))
+}
+
+func TestNewDAO(t *testing.T) {
+	require.Nil(t, NewDAO(""))
+	require.NotNil(t, NewDAO("token"))
+}
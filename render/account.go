@@ -0,0 +1,137 @@
+package render
+
+import (
+	"strings"
+
+	"github.com/nlopes/slack"
+)
+
+// AccountActionID identifies the overflow menu on an account result
+const AccountActionID = "account_actions"
+
+// CopyIDPrefix starts the value of the "Copy ID" option, the ID follows it
+const CopyIDPrefix = "copy_id:"
+
+// OpenValue is the value of the option that opens the account link
+const OpenValue = "open"
+
+// Field is a labelled value shown on an account
+type Field struct {
+	Label string
+	Value string
+}
+
+// Account is a customer as shown in search results
+type Account struct {
+	Title    string
+	Status   string
+	Fields   []Field
+	Context  []string
+	Link     string
+	LinkText string
+	ID       string
+}
+
+// Message lays out a page of accounts under a heading, followed by the footer blocks
+func Message(text string, accounts []*Account, footer []slack.Block) *slack.Msg {
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         text,
+	}
+	msg.Blocks.BlockSet = append(msg.Blocks.BlockSet, slack.NewSectionBlock(Markdown(text), nil, nil))
+	for _, account := range accounts {
+		msg.Blocks.BlockSet = append(msg.Blocks.BlockSet, Blocks(account)...)
+	}
+	msg.Blocks.BlockSet = append(msg.Blocks.BlockSet, footer...)
+	return msg
+}
+
+// Blocks renders an account as a section of fields with an overflow menu, and a context line
+func Blocks(account *Account) []slack.Block {
+	title := "*" + account.Title + "*"
+	if account.Status != "" {
+		title += " (" + account.Status + ")"
+	}
+	s := &section{
+		Type: slack.MBTSection,
+		Text: Markdown(title),
+	}
+	for _, field := range account.Fields {
+		s.Fields = append(s.Fields, Markdown("*"+field.Label+":*\n"+field.Value))
+	}
+	options := []*option{}
+	if account.Link != "" {
+		options = append(options, &option{Text: plain(account.LinkText), Value: OpenValue, URL: account.Link})
+	}
+	if account.ID != "" {
+		options = append(options, &option{Text: plain("Copy ID"), Value: CopyIDPrefix + account.ID})
+	}
+	switch {
+	case len(options) == 1:
+		// an overflow menu needs at least two options
+		s.Accessory = &button{Type: slack.METButton, ActionID: AccountActionID, Text: options[0].Text, Value: options[0].Value, URL: options[0].URL}
+	case len(options) > 1:
+		s.Accessory = &overflow{Type: slack.METOverflow, ActionID: AccountActionID, Options: options}
+	}
+	blocks := []slack.Block{s}
+	if len(account.Context) > 0 {
+		blocks = append(blocks, slack.NewContextBlock("", Markdown(strings.Join(account.Context, " · "))))
+	}
+	return blocks
+}
+
+// CopyIDMessage shows an ID on its own so it is easy to copy
+func CopyIDMessage(id string) *slack.Msg {
+	return Ephemeral("`" + id + "`")
+}
+
+// Ephemeral is a message only the person who asked sees
+func Ephemeral(text string) *slack.Msg {
+	return &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         text,
+	}
+}
+
+// Markdown is slack markdown text for a block
+func Markdown(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+}
+
+func plain(text string) *slack.TextBlockObject {
+	return slack.NewTextBlockObject(slack.PlainTextType, text, false, false)
+}
+
+// section, overflow, option and button mirror the slack types but leave out an empty url, which
+// slack rejects
+type section struct {
+	Type      slack.MessageBlockType   `json:"type"`
+	Text      *slack.TextBlockObject   `json:"text,omitempty"`
+	Fields    []*slack.TextBlockObject `json:"fields,omitempty"`
+	Accessory interface{}              `json:"accessory,omitempty"`
+}
+
+// BlockType returns the type of the block
+func (s *section) BlockType() slack.MessageBlockType {
+	return s.Type
+}
+
+type overflow struct {
+	Type     slack.MessageElementType `json:"type"`
+	ActionID string                   `json:"action_id"`
+	Options  []*option                `json:"options"`
+}
+
+type option struct {
+	Text  *slack.TextBlockObject `json:"text"`
+	Value string                 `json:"value"`
+	URL   string                 `json:"url,omitempty"`
+}
+
+type button struct {
+	Type     slack.MessageElementType `json:"type"`
+	ActionID string                   `json:"action_id"`
+	Text     *slack.TextBlockObject   `json:"text"`
+	Value    string                   `json:"value"`
+	URL      string                   `json:"url,omitempty"`
+}
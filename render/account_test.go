@@ -0,0 +1,48 @@
+package render
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlocksOverflow(t *testing.T) {
+	blocks := Blocks(&Account{
+		Title:    "shoes.com",
+		Status:   "Active",
+		Fields:   []Field{{Label: "Rep", Value: "Ashley Hilton"}},
+		Context:  []string{"Shoes", "Tracking code: abc123"},
+		Link:     "https://shoes.com",
+		LinkText: "Open website",
+		ID:       "abc123",
+	})
+	require.Len(t, blocks, 2)
+	body, err := json.Marshal(blocks[0])
+	require.Nil(t, err)
+	require.JSONEq(t, `{
+		"type": "section",
+		"text": {"type": "mrkdwn", "text": "*shoes.com* (Active)"},
+		"fields": [{"type": "mrkdwn", "text": "*Rep:*\nAshley Hilton"}],
+		"accessory": {
+			"type": "overflow",
+			"action_id": "account_actions",
+			"options": [
+				{"text": {"type": "plain_text", "text": "Open website"}, "value": "open", "url": "https://shoes.com"},
+				{"text": {"type": "plain_text", "text": "Copy ID"}, "value": "copy_id:abc123"}
+			]
+		}
+	}`, string(body))
+}
+
+func TestBlocksSingleActionIsAButton(t *testing.T) {
+	blocks := Blocks(&Account{Title: "shoes.com", ID: "abc123"})
+	require.Len(t, blocks, 1)
+	body, err := json.Marshal(blocks[0])
+	require.Nil(t, err)
+	require.JSONEq(t, `{
+		"type": "section",
+		"text": {"type": "mrkdwn", "text": "*shoes.com*"},
+		"accessory": {"type": "button", "action_id": "account_actions", "text": {"type": "plain_text", "text": "Copy ID"}, "value": "copy_id:abc123"}
+	}`, string(body))
+}
@@ -4,11 +4,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
-	"regexp"
-	"sort"
+	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/httpclient"
+	"github.com/searchspring/nebo/paging"
+	"github.com/searchspring/nebo/render"
 	"github.com/searchspring/nebo/validator"
 	"github.com/simpleforce/simpleforce"
 )
@@ -28,31 +34,75 @@ var Platforms = []string{
 	"Yahoo",
 }
 
-type accountInfo struct {
-	Website     string
-	Manager     string
-	Active      string
-	MRR         float64
-	FamilyMRR   float64
-	Platform    string
-	Integration string
-	Provider    string
+// Account is a customer account as found in salesforce
+type Account struct {
+	ID           string
+	Name         string
+	Website      string
+	Manager      string
+	ManagerID    string
+	Active       string
+	MRR          float64
+	FamilyMRR    float64
+	Platform     string
+	Integration  string
+	Provider     string
+	TrackingCode string
+	Link         string
 }
 
 // DAO acts as the salesforce DAO
 type DAO interface {
-	Query(query string) ([]byte, error)
+	Query(query string, offset int) ([]byte, error)
 	IDQuery(query string) ([]byte, error)
-	ResultToMessage(query string, result *simpleforce.QueryResult) ([]byte, error)
+	Accounts(query string) ([]*Account, error)
+	Show(term string) ([]byte, error)
+	ResultToMessage(query string, offset int, result *simpleforce.QueryResult) ([]byte, error)
 }
 
+// DefaultSessionTTL is how long a login is reused before logging in again
+const DefaultSessionTTL = time.Hour
+
+var logins int64
+
+// PageBlockID identifies the paging buttons on /nebo results
+const PageBlockID = "salesforce_page"
+
 // DAOImpl defines the properties of the DAO
 type DAOImpl struct {
-	Client *simpleforce.Client
+	Client     *simpleforce.Client
+	URL        string
+	User       string
+	Password   string
+	Token      string
+	SessionTTL time.Duration
+	// ContractStartField and RenewalField are the API names of the account date fields /nebo show
+	// lists, which differ between orgs. A blank name is not shown.
+	ContractStartField string
+	RenewalField       string
+	loggedIn           time.Time
+	mutex              sync.Mutex
+	rejected           *int64
 }
 
-// NewDAO returns the salesforce DAO
-func NewDAO(sfURL string, sfUser string, sfPassword string, sfToken string) DAO {
+// sessionWatch counts the responses salesforce sends when it no longer accepts the session, which
+// simpleforce reports as the same ErrFailure as a bad query
+type sessionWatch struct {
+	base     http.RoundTripper
+	rejected *int64
+}
+
+func (w *sessionWatch) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := w.base.RoundTrip(req)
+	if err == nil && res.StatusCode == http.StatusUnauthorized {
+		atomic.AddInt64(w.rejected, 1)
+	}
+	return res, err
+}
+
+// NewDAO returns the salesforce DAO. /nebo show lists the account date fields named by
+// contractStartField and renewalField, if they are not blank.
+func NewDAO(sfURL string, sfUser string, sfPassword string, sfToken string, contractStartField string, renewalField string) DAO {
 	if validator.ContainsEmptyString(sfURL, sfUser, sfPassword, sfToken) {
 		return nil
 	}
@@ -61,164 +111,356 @@ func NewDAO(sfURL string, sfUser string, sfPassword string, sfToken string) DAO
 		log.Println("nil returned from client creation")
 		return nil
 	}
-	err := client.LoginPassword(sfUser, sfPassword, sfToken)
+	httpClient := httpclient.New("Salesforce", 15*time.Second)
+	rejected := new(int64)
+	httpClient.Transport = &sessionWatch{base: httpClient.Transport, rejected: rejected}
+	client.SetHttpClient(httpClient)
+	dao := &DAOImpl{
+		Client:             client,
+		URL:                sfURL,
+		User:               sfUser,
+		Password:           sfPassword,
+		Token:              sfToken,
+		SessionTTL:         DefaultSessionTTL,
+		ContractStartField: contractStartField,
+		RenewalField:       renewalField,
+		rejected:           rejected,
+	}
+	err := dao.login()
 	if err != nil {
 		log.Println(err.Error())
 		return nil
 	}
-	return &DAOImpl{
-		Client: client,
-	}
+	return dao
 }
 
-func (s *DAOImpl) Query(search string) ([]byte, error) {
-	reg, err := regexp.Compile("[^a-zA-Z0-9_.-]+")
+// Logins returns how many times this process has logged in to salesforce
+func Logins() int64 {
+	return atomic.LoadInt64(&logins)
+}
+
+func (s *DAOImpl) login() error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := s.Client.LoginPassword(s.User, s.Password, s.Token)
 	if err != nil {
-		return nil, err
+		return err
 	}
+	s.loggedIn = time.Now()
+	log.Printf("salesforce login %d for this process", atomic.AddInt64(&logins, 1))
+	return nil
+}
+
+func (s *DAOImpl) sessionExpired() bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return time.Since(s.loggedIn) > s.SessionTTL
+}
 
-	sanitized := reg.ReplaceAllString(search, "")
+// sessionRejections is how many times salesforce has refused the session so far
+func (s *DAOImpl) sessionRejections() int64 {
+	if s.rejected == nil {
+		return 0
+	}
+	return atomic.LoadInt64(s.rejected)
+}
 
-	q := "SELECT Type, Website, CS_Manager__r.Name, Family_MRR__c, Chargify_MRR__c, Platform__c, Integration_Type__c, Chargify_Source__c " +
-		"FROM Account WHERE Type IN ('Customer', 'Inactive Customer') " +
-		"AND (Website LIKE '%" + sanitized + "%' OR Platform__c LIKE '%" + sanitized +
-		"%' OR Tracking_Code__c = '" + sanitized + "') ORDER BY Chargify_MRR__c DESC"
+// query runs the SOQL, logging in again if the session has expired or salesforce rejects it. Other
+// failures, such as a malformed query, are returned without logging in again.
+func (s *DAOImpl) query(q string) (*simpleforce.QueryResult, error) {
+	if s.sessionExpired() {
+		err := s.login()
+		if err != nil {
+			return nil, err
+		}
+	}
+	rejections := s.sessionRejections()
 	result, err := s.Client.Query(q)
+	if err == simpleforce.ErrAuthentication || (err == simpleforce.ErrFailure && s.sessionRejections() > rejections) {
+		log.Println("salesforce rejected the session, logging in again: " + err.Error())
+		err = s.login()
+		if err != nil {
+			return nil, err
+		}
+		return s.Client.Query(q)
+	}
+	return result, err
+}
+
+var accountFields = []string{
+	"Id",
+	"Type",
+	"Name",
+	"Website",
+	"CS_Manager__c",
+	"CS_Manager__r.Name",
+	"Family_MRR__c",
+	"Chargify_MRR__c",
+	"Platform__c",
+	"Integration_Type__c",
+	"Chargify_Source__c",
+	"Tracking_Code__c",
+}
+
+func accountQuery() *soqlQuery {
+	return selectFields(accountFields...).
+		from("Account").
+		where(in("Type", "Customer", "Inactive Customer")).
+		orderBy("Chargify_MRR__c", true)
+}
+
+// Query finds customers whose website, name or platform match every term of the search, or whose
+// tracking code is the search. The search may also use filters, see FilterHelp. Results are shown a
+// page at a time starting at offset.
+func (s *DAOImpl) Query(search string, offset int) ([]byte, error) {
+	search = strings.TrimSpace(search)
+	f, err := parseFilter(search)
+	if err != nil {
+		return json.Marshal(invalidSearchMessage(err))
+	}
+	q := accountQuery().where(f.conditions()...)
+	result, err := s.query(q.String())
 	if err != nil {
 		return nil, err
 	}
-	return s.ResultToMessage(sanitized, result)
+	return s.ResultToMessage(search, offset, result)
 }
 
-func (s *DAOImpl) IDQuery(search string) ([]byte, error) {
-	reg, err := regexp.Compile("[^a-zA-Z0-9_.-]+")
+// Accounts finds customers the same way as Query, returning every match best first
+func (s *DAOImpl) Accounts(search string) ([]*Account, error) {
+	search = strings.TrimSpace(search)
+	f, err := parseFilter(search)
 	if err != nil {
 		return nil, err
 	}
+	result, err := s.query(accountQuery().where(f.conditions()...).String())
+	if err != nil {
+		return nil, err
+	}
+	return s.toAccounts(search, result), nil
+}
 
-	sanitized := reg.ReplaceAllString(search, "")
+// IsInvalidSearch reports whether the error is a search that could not be understood
+func IsInvalidSearch(err error) bool {
+	_, ok := err.(*filterError)
+	return ok
+}
 
-	q := "SELECT Type, Website, CS_Manager__r.Name, Family_MRR__c, Chargify_MRR__c, Platform__c, Integration_Type__c, Chargify_Source__c " +
-		"FROM Account WHERE Type IN ('Customer', 'Inactive Customer') AND Tracking_Code__c = '" + sanitized + "' ORDER BY Chargify_MRR__c DESC"
-	result, err := s.Client.Query(q)
+// IDQuery finds customers by tracking code
+func (s *DAOImpl) IDQuery(search string) ([]byte, error) {
+	search = strings.TrimSpace(search)
+	q := accountQuery().where(equals("Tracking_Code__c", search))
+	result, err := s.query(q.String())
 	if err != nil {
 		return nil, err
 	}
-	return s.ResultToMessage(sanitized, result)
+	return s.resultToMessage(search, 0, "", result)
+}
+
+// ResultToMessage formats the page of results starting at offset, with buttons to page through the rest
+func (s *DAOImpl) ResultToMessage(search string, offset int, result *simpleforce.QueryResult) ([]byte, error) {
+	return s.resultToMessage(search, offset, PageBlockID, result)
+}
+
+func (s *DAOImpl) resultToMessage(search string, offset int, blockID string, result *simpleforce.QueryResult) ([]byte, error) {
+	accounts := s.toAccounts(search, result)
+	start, end := paging.Bounds(offset, len(accounts))
+	footer := []slack.Block{}
+	if len(accounts) > 0 {
+		footer = paging.Blocks(blockID, search, start, len(accounts))
+	}
+	msg := formatAccountInfos(accounts[start:end], search, s.URL, footer)
+	return json.Marshal(msg)
 }
 
-func (s *DAOImpl) ResultToMessage(search string, result *simpleforce.QueryResult) ([]byte, error) {
-	accounts := []*accountInfo{}
+// toAccounts reads the records, ranking them by how well they match the words of the search
+func (s *DAOImpl) toAccounts(search string, result *simpleforce.QueryResult) []*Account {
+	accounts := []*Account{}
 	for _, record := range result.Records {
-		manager := record["CS_Manager__r"]
-		managerName := "unknown"
-		if manager != nil {
-			if mapName, ok := (manager.(map[string]interface{}))["Name"]; ok {
-				managerName = fmt.Sprintf("%s", mapName)
+		accounts = append(accounts, toAccount(record))
+	}
+	accounts = cleanAccounts(accounts)
+	for _, account := range accounts {
+		account.Link = accountURL(s.URL, account)
+	}
+	if f, err := parseFilter(search); err == nil && len(f.Terms) > 0 {
+		matches := []*Account{}
+		for _, account := range accounts {
+			if matchesPhrases(account, f.Terms) {
+				matches = append(matches, account)
 			}
 		}
-		Type := record["Type"]
-		active := "Active"
-		if Type != "Customer" {
-			active = "Not active"
-		}
-		platform := "unknown"
-		if record["Platform__c"] != nil {
-			platform = fmt.Sprintf("%s", record["Platform__c"])
-		}
-		integration := "unknown"
-		if record["Integration_Type__c"] != nil {
-			integration = fmt.Sprintf("%s", record["Integration_Type__c"])
-		}
-		provider := "unknown"
-		if record["Chargify_Source__c"] != nil {
-			provider = fmt.Sprintf("%s", record["Chargify_Source__c"])
-		}
-		mrr := float64(-1)
-		if record["Chargify_MRR__c"] != nil {
-			mrr = record["Chargify_MRR__c"].(float64)
-		}
-		familymrr := float64(-1)
-		if record["Family_MRR__c"] != nil {
-			familymrr = record["Family_MRR__c"].(float64)
+		accounts = rankAccounts(matches, f.text())
+	}
+	return accounts
+}
+
+// toAccount reads the account fields of the record, see accountFields
+func toAccount(record simpleforce.SObject) *Account {
+	manager := record["CS_Manager__r"]
+	managerName := "unknown"
+	if manager != nil {
+		if mapName, ok := (manager.(map[string]interface{}))["Name"]; ok {
+			managerName = fmt.Sprintf("%s", mapName)
 		}
+	}
+	Type := record["Type"]
+	active := "Active"
+	if Type != "Customer" {
+		active = "Not active"
+	}
+	platform := "unknown"
+	if record["Platform__c"] != nil {
+		platform = fmt.Sprintf("%s", record["Platform__c"])
+	}
+	integration := "unknown"
+	if record["Integration_Type__c"] != nil {
+		integration = fmt.Sprintf("%s", record["Integration_Type__c"])
+	}
+	provider := "unknown"
+	if record["Chargify_Source__c"] != nil {
+		provider = fmt.Sprintf("%s", record["Chargify_Source__c"])
+	}
+	mrr := float64(-1)
+	if record["Chargify_MRR__c"] != nil {
+		mrr = record["Chargify_MRR__c"].(float64)
+	}
+	familymrr := float64(-1)
+	if record["Family_MRR__c"] != nil {
+		familymrr = record["Family_MRR__c"].(float64)
+	}
 
-		accounts = append(accounts, &accountInfo{
-			Website:     fmt.Sprintf("%s", record["Website"]),
-			Manager:     fmt.Sprintf("%s", managerName),
-			Active:      fmt.Sprintf("%s", active),
-			MRR:         mrr,
-			FamilyMRR:   familymrr,
-			Platform:    platform,
-			Integration: integration,
-			Provider:    provider,
-		})
+	name := ""
+	if record["Name"] != nil {
+		name = fmt.Sprintf("%s", record["Name"])
 	}
-	accounts = cleanAccounts(accounts)
-	if !isPlatformSearch(search) {
-		accounts = sortAccounts(accounts)
+	website := ""
+	if record["Website"] != nil {
+		website = fmt.Sprintf("%s", record["Website"])
+	}
+	trackingCode := ""
+	if record["Tracking_Code__c"] != nil {
+		trackingCode = fmt.Sprintf("%s", record["Tracking_Code__c"])
+	}
+	managerID := ""
+	if manager != nil && record["CS_Manager__c"] != nil {
+		managerID = fmt.Sprintf("%s", record["CS_Manager__c"])
 	}
-	accounts = truncateAccounts(accounts)
-	msg := formatAccountInfos(accounts, search)
-	return json.Marshal(msg)
-}
 
-func truncateAccounts(accounts []*accountInfo) []*accountInfo {
-	truncated := []*accountInfo{}
-	for i, account := range accounts {
-		if i == 20 {
-			break
-		}
-		truncated = append(truncated, account)
+	return &Account{
+		ID:           record.ID(),
+		Name:         name,
+		Website:      website,
+		Manager:      fmt.Sprintf("%s", managerName),
+		ManagerID:    managerID,
+		Active:       fmt.Sprintf("%s", active),
+		MRR:          mrr,
+		FamilyMRR:    familymrr,
+		Platform:     platform,
+		Integration:  integration,
+		Provider:     provider,
+		TrackingCode: trackingCode,
 	}
-	return truncated
 }
-func isPlatformSearch(search string) bool {
-	for _, platform := range Platforms {
-		if strings.ToLower(search) == strings.ToLower(platform) {
-			return true
-		}
+
+func invalidSearchMessage(err error) *slack.Msg {
+	return &slack.Msg{
+		ResponseType: slack.ResponseTypeEphemeral,
+		Text:         "Sorry, I " + err.Error() + "\nSearch with words, \"quoted phrases\" and filters like " + FilterHelp,
 	}
-	return false
 }
 
-// example formatting here: https://api.slack.com/reference/messaging/attachments
-func formatAccountInfos(accountInfos []*accountInfo, search string) *slack.Msg {
+// formatAccountInfos lays the accounts out with the shared account renderer, see
+// https://api.slack.com/reference/block-kit/blocks
+func formatAccountInfos(accountInfos []*Account, search string, sfURL string, footer []slack.Block) *slack.Msg {
 	initialText := "Reps for search: " + search
 	if len(accountInfos) == 0 {
 		initialText = "No results for: " + search
 	}
+	accounts := []*render.Account{}
+	for _, ai := range accountInfos {
+		accounts = append(accounts, renderAccount(ai, sfURL))
+	}
+	return render.Message(initialText, accounts, footer)
+}
 
-	msg := &slack.Msg{
-		ResponseType: slack.ResponseTypeInChannel,
-		Text:         initialText,
-		Attachments:  []slack.Attachment{},
+func renderAccount(ai *Account, sfURL string) *render.Account {
+	manager := ai.Manager
+	if manager == "unknown" {
+		manager = ":warning: unknown"
+	} else if ai.ManagerID != "" && sfURL != "" {
+		manager = "<" + recordURL(sfURL, ai.ManagerID) + "|" + manager + ">"
 	}
-	for _, ai := range accountInfos {
-		color := "3A23AD" // Searchspring purple
-		if ai.Manager == "unknown" {
-			color = "FF0000" // red
-		}
-		mrr := "unknown"
-		if ai.MRR != -1 {
-			mrr = fmt.Sprintf("$%.2f", ai.MRR)
-		}
-		familymrr := "unknown"
-		if ai.FamilyMRR != -1 {
-			familymrr = fmt.Sprintf("$%.2f", ai.FamilyMRR)
-		}
-		mrr = mrr + " (Family MRR: " + familymrr + ")"
-		text := "Rep: " + ai.Manager + "\nMRR: " + mrr + "\nPlatform: " + ai.Platform + "\nIntegration: " + ai.Integration + "\nProvider: " + ai.Provider
-		msg.Attachments = append(msg.Attachments, slack.Attachment{
-			Color:      "#" + color,
-			Text:       text,
-			AuthorName: ai.Website + " (" + ai.Active + ")",
-		})
+	context := []string{}
+	if ai.Name != "" {
+		context = append(context, ai.Name)
+	}
+	if ai.TrackingCode != "" {
+		context = append(context, "Tracking code: "+ai.TrackingCode)
+	}
+	account := &render.Account{
+		Title:  ai.Website,
+		Status: ai.Active,
+		Fields: []render.Field{
+			{Label: "Rep", Value: manager},
+			{Label: "MRR", Value: FormatMoney(ai.MRR)},
+			{Label: "Family MRR", Value: FormatMoney(ai.FamilyMRR)},
+			{Label: "Platform", Value: ai.Platform},
+			{Label: "Integration", Value: ai.Integration},
+			{Label: "Provider", Value: ai.Provider},
+		},
+		Context: context,
+		ID:      ai.TrackingCode,
+	}
+	if link := accountURL(sfURL, ai); link != "" {
+		account.Link = link
+		account.LinkText = "Open in Salesforce"
+	}
+	return account
+}
+
+// FormatMoney shows an amount in dollars, or "unknown" for the -1 salesforce accounts have when the
+// amount is not set
+func FormatMoney(amount float64) string {
+	if amount == -1 {
+		return "unknown"
 	}
-	return msg
+	return fmt.Sprintf("$%.2f", amount)
 }
 
-func cleanAccounts(accounts []*accountInfo) []*accountInfo {
+// accountURL links to the account's record, or to a search for its website if the ID is not known
+func accountURL(sfURL string, account *Account) string {
+	switch {
+	case sfURL == "":
+		return ""
+	case account.ID != "":
+		return recordURL(sfURL, account.ID)
+	default:
+		return searchURL(sfURL, account.Website)
+	}
+}
+
+// recordURL links to the record with the ID on the salesforce instance, salesforce redirects it to
+// the lightning page for whatever kind of record it is
+func recordURL(sfURL string, id string) string {
+	return instanceURL(sfURL) + "/" + url.PathEscape(id)
+}
+
+// searchURL links to a salesforce search for the term
+func searchURL(sfURL string, term string) string {
+	return instanceURL(sfURL) + "/_ui/search/ui/UnifiedSearchResults?str=" + url.QueryEscape(term)
+}
+
+// instanceURL drops any path from the configured url, leaving the scheme and host
+func instanceURL(sfURL string) string {
+	u, err := url.Parse(strings.TrimSpace(sfURL))
+	if err != nil || u.Host == "" {
+		return strings.TrimSuffix(sfURL, "/")
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func cleanAccounts(accounts []*Account) []*Account {
 	for _, account := range accounts {
 		w := account.Website
 		if strings.HasPrefix(w, "http://") || strings.HasPrefix(w, "https://") {
@@ -234,9 +476,3 @@ func cleanAccounts(accounts []*accountInfo) []*accountInfo {
 	}
 	return accounts
 }
-func sortAccounts(accounts []*accountInfo) []*accountInfo {
-	sort.Slice(accounts, func(i, j int) bool {
-		return len(accounts[i].Website) < len(accounts[j].Website)
-	})
-	return accounts
-}
@@ -2,6 +2,10 @@ package salesforce
 
 import (
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -17,7 +21,11 @@ func createQueryResults() *simpleforce.QueryResult {
 	json.Unmarshal([]byte(`{ "totalSize": 1,
 		"done": true,
 		"records": [{ 
+				"Id": "0013600001XyZabAAF",
+				"Name": "Fabletics",
 				"Website": "fabletics.com",
+				"Tracking_Code__c": "m6umjp",
+				"CS_Manager__c": "0053600000AbCdeAAB",
 				"CS_Manager__r": { "Name": "Ashley Hilton" },
 				"Family_MRR__c": 14858.54,
 				"Chargify_MRR__c": 3955.17,
@@ -29,24 +37,203 @@ func createQueryResults() *simpleforce.QueryResult {
 	return qr
 }
 
+// accountSections returns the section blocks that show accounts, skipping the heading
+func accountSections(msg *slack.Msg) []*slack.SectionBlock {
+	sections := []*slack.SectionBlock{}
+	for _, block := range msg.Blocks.BlockSet[1:] {
+		if section, ok := block.(*slack.SectionBlock); ok {
+			sections = append(sections, section)
+		}
+	}
+	return sections
+}
+
+func fieldTexts(section *slack.SectionBlock) []string {
+	texts := []string{}
+	for _, field := range section.Fields {
+		texts = append(texts, field.Text)
+	}
+	return texts
+}
+
 func TestFormatAccountInfos(t *testing.T) {
-	dao := &DAOImpl{}
-	response, err := dao.ResultToMessage("search term", createQueryResults())
+	dao := &DAOImpl{URL: "https://searchspring.my.salesforce.com"}
+	response, err := dao.ResultToMessage("search term", 0, createQueryResults())
 	require.Nil(t, err)
 	msg := &slack.Msg{}
 	err = json.Unmarshal(response, msg)
 	require.Nil(t, err)
 	require.True(t, strings.Contains(msg.Text, "search term"))
-	require.True(t, strings.Contains(msg.Attachments[0].Text, "Rep: Ashley Hilton"))
-	require.True(t, strings.Contains(msg.Attachments[0].Text, "MRR: $3955.17"))
-	require.True(t, strings.Contains(msg.Attachments[0].Text, "Platform: Custom"))
-	require.True(t, strings.Contains(msg.Attachments[0].Text, "Integration: v3"))
-	require.True(t, strings.Contains(msg.Attachments[0].Text, "Provider: Searchspring"))
-	require.True(t, strings.Contains(msg.Attachments[0].Text, "Family MRR: $14858.54"))
-	require.Equal(t, "fabletics.com (Not active)", msg.Attachments[0].AuthorName)
-	require.Equal(t, "#3A23AD", msg.Attachments[0].Color)
+	require.Empty(t, msg.Attachments)
+	sections := accountSections(msg)
+	require.Len(t, sections, 1)
+	require.Equal(t, "*fabletics.com* (Not active)", sections[0].Text.Text)
+	require.Equal(t, []string{
+		"*Rep:*\n<https://searchspring.my.salesforce.com/0053600000AbCdeAAB|Ashley Hilton>",
+		"*MRR:*\n$3955.17",
+		"*Family MRR:*\n$14858.54",
+		"*Platform:*\nCustom",
+		"*Integration:*\nv3",
+		"*Provider:*\nSearchspring",
+	}, fieldTexts(sections[0]))
+	overflow := sections[0].Accessory.OverflowElement
+	require.NotNil(t, overflow)
+	require.Equal(t, "Open in Salesforce", overflow.Options[0].Text.Text)
+	require.Equal(t, "https://searchspring.my.salesforce.com/0013600001XyZabAAF", overflow.Options[0].URL)
+	require.Equal(t, "Copy ID", overflow.Options[1].Text.Text)
+	require.Equal(t, "copy_id:m6umjp", overflow.Options[1].Value)
+	context := msg.Blocks.BlockSet[2].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	require.Equal(t, "Fabletics · Tracking code: m6umjp", context.Text)
+}
+
+func TestFormatAccountInfosFlagsMissingRep(t *testing.T) {
+	body, err := json.Marshal(formatAccountInfos([]*Account{{Website: "shoes.com", Manager: "unknown", MRR: -1, FamilyMRR: -1}}, "shoes", "", nil))
+	require.Nil(t, err)
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(body, msg))
+	sections := accountSections(msg)
+	require.Equal(t, "*Rep:*\n:warning: unknown", sections[0].Fields[0].Text)
+	require.Equal(t, "*MRR:*\nunknown", sections[0].Fields[1].Text)
+	require.Nil(t, sections[0].Accessory)
+}
+
+func TestRecordURL(t *testing.T) {
+	tests := []struct {
+		name  string
+		sfURL string
+		id    string
+		url   string
+	}{
+		{"production", "https://searchspring.my.salesforce.com", "0013600001XyZabAAF", "https://searchspring.my.salesforce.com/0013600001XyZabAAF"},
+		{"production with trailing slash", "https://searchspring.my.salesforce.com/", "0013600001XyZabAAF", "https://searchspring.my.salesforce.com/0013600001XyZabAAF"},
+		{"sandbox", "https://searchspring--uat.sandbox.my.salesforce.com", "0013600001XyZabAAF", "https://searchspring--uat.sandbox.my.salesforce.com/0013600001XyZabAAF"},
+		{"legacy sandbox", "https://searchspring--uat.my.salesforce.com", "0053600000AbCdeAAB", "https://searchspring--uat.my.salesforce.com/0053600000AbCdeAAB"},
+		{"instance url with a path", "https://cs42.salesforce.com/services/Soap/u/43.0", "0013600001XyZabAAF", "https://cs42.salesforce.com/0013600001XyZabAAF"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.Equal(t, test.url, recordURL(test.sfURL, test.id))
+		})
+	}
+}
+
+func TestSearchURLWithoutRecordID(t *testing.T) {
+	account := renderAccount(&Account{Website: "shoes.com", Manager: "Ashley Hilton", ManagerID: "0053600000AbCdeAAB"}, "https://searchspring--uat.sandbox.my.salesforce.com/")
+	require.Equal(t, "https://searchspring--uat.sandbox.my.salesforce.com/_ui/search/ui/UnifiedSearchResults?str=shoes.com", account.Link)
+	require.Equal(t, "<https://searchspring--uat.sandbox.my.salesforce.com/0053600000AbCdeAAB|Ashley Hilton>", account.Fields[0].Value)
+}
+
+func createManyQueryResults(count int) *simpleforce.QueryResult {
+	qr := &simpleforce.QueryResult{TotalSize: count, Done: true}
+	for i := 0; i < count; i++ {
+		qr.Records = append(qr.Records, simpleforce.SObject{
+			"Type":            "Customer",
+			"Website":         fmt.Sprintf("shoes%03d.com", i),
+			"Chargify_MRR__c": float64(count - i),
+		})
+	}
+	return qr
+}
+
+func TestResultToMessagePages(t *testing.T) {
+	dao := &DAOImpl{}
+	response, err := dao.ResultToMessage("shopify", 40, createManyQueryResults(45))
+	require.Nil(t, err)
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	sections := accountSections(msg)
+	require.Len(t, sections, 5)
+	require.Equal(t, "*shoes040.com* (Active)", sections[0].Text.Text)
+	require.Len(t, msg.Blocks.BlockSet, 1+5+2)
+	summary := msg.Blocks.BlockSet[6].(*slack.ContextBlock).ContextElements.Elements[0].(*slack.TextBlockObject)
+	require.Equal(t, "showing 41–45 of 45", summary.Text)
+	buttons := msg.Blocks.BlockSet[7].(*slack.ActionBlock)
+	require.Equal(t, PageBlockID, buttons.BlockID)
+	require.Len(t, buttons.Elements.ElementSet, 1)
+	require.Equal(t, "previous", buttons.Elements.ElementSet[0].(*slack.ButtonBlockElement).ActionID)
 }
 
 func c(b []byte, e error) string {
 	return string(b)
 }
+
+// fakeSalesforce logs in with numbered sessions and rejects queries from expired ones
+func fakeSalesforce(t *testing.T, validSession string) (*httptest.Server, *int) {
+	logins := 0
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.Contains(r.URL.Path, "/services/Soap/u/"):
+			logins++
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+				<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><loginResponse><result>
+				<serverUrl>` + server.URL + `/services/Soap/u/43.0/00D</serverUrl>
+				<sessionId>session-` + strconv.Itoa(logins) + `</sessionId>
+				</result></loginResponse></soapenv:Body></soapenv:Envelope>`))
+		case strings.Contains(r.URL.Path, "/services/data/"):
+			if strings.Contains(r.URL.RawQuery, "MALFORMED") {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`[{"message":"unexpected token","errorCode":"MALFORMED_QUERY"}]`))
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer "+validSession {
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte(`[{"message":"Session expired or invalid","errorCode":"INVALID_SESSION_ID"}]`))
+				return
+			}
+			w.Write([]byte(`{"totalSize":0,"done":true,"records":[]}`))
+		default:
+			t.Errorf("unexpected request %s", r.URL.Path)
+		}
+	}))
+	return server, &logins
+}
+
+func TestQueryLogsInAgainWhenSessionIsRejected(t *testing.T) {
+	server, logins := fakeSalesforce(t, "session-2")
+	defer server.Close()
+
+	dao := NewDAO(server.URL, "user", "password", "token", "", "")
+	require.NotNil(t, dao)
+	require.Equal(t, 1, *logins)
+
+	_, err := dao.IDQuery("m6umjp")
+	require.Nil(t, err)
+	require.Equal(t, 2, *logins)
+
+	_, err = dao.IDQuery("m6umjp")
+	require.Nil(t, err)
+	require.Equal(t, 2, *logins)
+}
+
+func TestQueryDoesNotLogInAgainForBadQueries(t *testing.T) {
+	server, logins := fakeSalesforce(t, "session-1")
+	defer server.Close()
+
+	dao := NewDAO(server.URL, "user", "password", "token", "", "").(*DAOImpl)
+	_, err := dao.query("SELECT MALFORMED")
+	require.Equal(t, simpleforce.ErrFailure, err)
+	require.Equal(t, 1, *logins)
+}
+
+func TestQueryLogsInAgainWhenSessionIsOld(t *testing.T) {
+	server, logins := fakeSalesforce(t, "session-2")
+	defer server.Close()
+
+	dao := NewDAO(server.URL, "user", "password", "token", "", "").(*DAOImpl)
+	dao.SessionTTL = 0
+	_, err := dao.IDQuery("m6umjp")
+	require.Nil(t, err)
+	require.Equal(t, 2, *logins)
+}
+
+func TestToAccountsLinksRecords(t *testing.T) {
+	dao := &DAOImpl{URL: "https://searchspring.my.salesforce.com"}
+	accounts := dao.toAccounts("fabletics", createQueryResults())
+	require.Len(t, accounts, 1)
+	require.Equal(t, "fabletics.com", accounts[0].Website)
+	require.Equal(t, "https://searchspring.my.salesforce.com/0013600001XyZabAAF", accounts[0].Link)
+	require.False(t, IsInvalidSearch(nil))
+	_, err := parseFilter("mrr>lots")
+	require.True(t, IsInvalidSearch(err))
+}
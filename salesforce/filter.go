@@ -0,0 +1,163 @@
+package salesforce
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// FilterHelp describes the filters a search may use
+const FilterHelp = "`platform:magento` `rep:\"Ashley Hilton\"` `mrr>1000` `active:true`"
+
+// filter is a parsed /nebo search
+type filter struct {
+	Terms    []string
+	Platform string
+	Rep      string
+	MRR      []mrrFilter
+	Active   *bool
+}
+
+// mrrFilter compares the account MRR with a value
+type mrrFilter struct {
+	Operator string
+	Value    float64
+}
+
+// filterError explains why a search could not be parsed
+type filterError struct {
+	Token  string
+	Reason string
+}
+
+func (e *filterError) Error() string {
+	return "couldn't understand `" + e.Token + "`: " + e.Reason
+}
+
+var filterPattern = regexp.MustCompile(`^([a-zA-Z]+)(:|>=|<=|>|<|=)(.*)$`)
+
+// filterMatch splits a filter token into its key, operator and value, or returns nil if the token is
+// not a filter. Websites such as https://shoes.com are not filters.
+func filterMatch(token string) []string {
+	match := filterPattern.FindStringSubmatch(token)
+	if match == nil || strings.HasPrefix(match[3], "//") {
+		return nil
+	}
+	return match
+}
+
+// SearchText is the search without its filters, for backends that can only match the text
+func SearchText(search string) string {
+	text := []string{}
+	for _, token := range tokenize(search) {
+		if filterMatch(token) == nil {
+			text = append(text, token)
+		}
+	}
+	return strings.Join(text, " ")
+}
+
+// parseFilter splits a search into filters and free text terms
+func parseFilter(search string) (*filter, error) {
+	f := &filter{}
+	for _, token := range tokenize(search) {
+		match := filterMatch(token)
+		if match == nil {
+			if term := unquote(token); term != "" {
+				f.Terms = append(f.Terms, term)
+			}
+			continue
+		}
+		key := strings.ToLower(match[1])
+		operator := match[2]
+		value := unquote(match[3])
+		if value == "" {
+			return nil, &filterError{token, key + " needs a value"}
+		}
+		err := f.add(token, key, operator, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(f.Terms) == 1 && f.Platform == "" && len(f.MRR) == 0 && f.Rep == "" && f.Active == nil {
+		if platform, ok := findPlatform(f.Terms[0]); ok {
+			f.Platform = platform
+			f.Terms = nil
+		}
+	}
+	return f, nil
+}
+
+func (f *filter) add(token string, key string, operator string, value string) error {
+	if key != "mrr" && operator != ":" && operator != "=" {
+		return &filterError{token, key + " can only be matched with `:`"}
+	}
+	switch key {
+	case "platform":
+		platform, ok := findPlatform(value)
+		if !ok {
+			return &filterError{token, "unknown platform, try one of " + strings.ToLower(strings.Join(Platforms, ", "))}
+		}
+		f.Platform = platform
+	case "rep":
+		f.Rep = value
+	case "mrr":
+		mrr, err := strconv.ParseFloat(strings.TrimPrefix(strings.ReplaceAll(value, ",", ""), "$"), 64)
+		if err != nil {
+			return &filterError{token, "mrr needs a number, e.g. `mrr>1000`"}
+		}
+		if operator == ":" {
+			operator = "="
+		}
+		f.MRR = append(f.MRR, mrrFilter{operator, mrr})
+	case "active":
+		active, err := strconv.ParseBool(value)
+		if err != nil {
+			return &filterError{token, "active needs true or false"}
+		}
+		f.Active = &active
+	default:
+		return &filterError{token, fmt.Sprintf("unknown filter `%s`, try %s", key, FilterHelp)}
+	}
+	return nil
+}
+
+func findPlatform(value string) (string, bool) {
+	for _, platform := range Platforms {
+		if strings.EqualFold(value, platform) {
+			return platform, true
+		}
+	}
+	return "", false
+}
+
+// conditions compiles the filter into SOQL conditions that must all hold
+func (f *filter) conditions() []condition {
+	conditions := []condition{}
+	if len(f.Terms) > 0 {
+		conditions = append(conditions, searchCondition(f.Terms))
+	}
+	if f.Platform != "" {
+		conditions = append(conditions, equals("Platform__c", f.Platform))
+	}
+	if f.Rep != "" {
+		conditions = append(conditions, contains("CS_Manager__r.Name", f.Rep))
+	}
+	for _, mrr := range f.MRR {
+		conditions = append(conditions, compare("Chargify_MRR__c", mrr.Operator, mrr.Value))
+	}
+	if f.Active != nil {
+		accountType := "Customer"
+		if !*f.Active {
+			accountType = "Inactive Customer"
+		}
+		conditions = append(conditions, equals("Type", accountType))
+	}
+	return conditions
+}
+
+// text is the free text part of the search, used to rank results
+func (f *filter) text() string {
+	return strings.Join(f.Terms, " ")
+}
@@ -0,0 +1,82 @@
+package salesforce
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	f, err := parseFilter(`red wing platform:magento rep:"Ashley Hilton" mrr>1000 mrr<=5,000 active:true`)
+	require.Nil(t, err)
+	active := true
+	require.Equal(t, &filter{
+		Terms:    []string{"red", "wing"},
+		Platform: "Magento",
+		Rep:      "Ashley Hilton",
+		MRR:      []mrrFilter{{">", 1000}, {"<=", 5000}},
+		Active:   &active,
+	}, f)
+	require.Equal(t, []condition{
+		"((Website LIKE '%red%' OR Name LIKE '%red%' OR Platform__c LIKE '%red%') AND (Website LIKE '%wing%' OR Name LIKE '%wing%' OR Platform__c LIKE '%wing%'))",
+		"Platform__c = 'Magento'",
+		"CS_Manager__r.Name LIKE '%Ashley Hilton%'",
+		"Chargify_MRR__c > 1000",
+		"Chargify_MRR__c <= 5000",
+		"Type = 'Customer'",
+	}, f.conditions())
+}
+
+func TestSearchText(t *testing.T) {
+	require.Equal(t, `red "wing shoes" https://shoes.com`, SearchText(`red platform:magento "wing shoes" mrr>=100 rep:"Ashley Hilton" https://shoes.com`))
+	require.Equal(t, "", SearchText("active:true"))
+}
+
+func TestParseFilterPlatformShorthand(t *testing.T) {
+	f, err := parseFilter("shopify plus")
+	require.Nil(t, err)
+	require.Equal(t, []string{"shopify", "plus"}, f.Terms)
+
+	f, err = parseFilter(`"shopify plus"`)
+	require.Nil(t, err)
+	require.Equal(t, "Shopify Plus", f.Platform)
+	require.Empty(t, f.Terms)
+}
+
+func TestParseFilterKeepsURLsAsText(t *testing.T) {
+	f, err := parseFilter("https://shoes.com")
+	require.Nil(t, err)
+	require.Equal(t, []string{"https://shoes.com"}, f.Terms)
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		search string
+		err    string
+	}{
+		{"platform:magneto", "couldn't understand `platform:magneto`: unknown platform"},
+		{"mrr>lots", "couldn't understand `mrr>lots`: mrr needs a number"},
+		{"active:maybe", "couldn't understand `active:maybe`: active needs true or false"},
+		{"rep:", "couldn't understand `rep:`: rep needs a value"},
+		{"rep>bob", "couldn't understand `rep>bob`: rep can only be matched with `:`"},
+		{"owner:bob", "couldn't understand `owner:bob`: unknown filter `owner`"},
+	}
+	for _, test := range tests {
+		_, err := parseFilter(test.search)
+		require.NotNil(t, err, test.search)
+		require.True(t, strings.HasPrefix(err.Error(), test.err), err.Error())
+	}
+}
+
+func TestQueryInvalidFilterIsEphemeral(t *testing.T) {
+	dao := &DAOImpl{}
+	response, err := dao.Query("mrr>lots", 0)
+	require.Nil(t, err)
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.True(t, strings.Contains(msg.Text, "mrr needs a number"))
+}
@@ -0,0 +1,178 @@
+package salesforce
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// searchFields are the account fields every search term is matched against
+var searchFields = []string{"Website", "Name", "Platform__c"}
+
+// tokenize splits a search on whitespace that is not inside double quotes. The quotes are kept so
+// that filters like rep:"Ashley Hilton" come through as one token.
+func tokenize(search string) []string {
+	search = strings.NewReplacer("“", `"`, "”", `"`).Replace(search)
+	tokens := []string{}
+	token := strings.Builder{}
+	quoted := false
+	for _, r := range search {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			token.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if token.Len() > 0 {
+				tokens = append(tokens, token.String())
+				token.Reset()
+			}
+		default:
+			token.WriteRune(r)
+		}
+	}
+	if token.Len() > 0 {
+		tokens = append(tokens, token.String())
+	}
+	return tokens
+}
+
+// unquote drops the quotes from a token and tidies the whitespace inside a quoted phrase
+func unquote(token string) string {
+	return strings.Join(strings.Fields(strings.ReplaceAll(token, `"`, " ")), " ")
+}
+
+// parseSearch splits a search into terms. Quoted phrases are kept together, everything else is split
+// on whitespace.
+func parseSearch(search string) []string {
+	terms := []string{}
+	for _, token := range tokenize(search) {
+		if term := unquote(token); term != "" {
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+// words returns the letters and digits of a term, split wherever there is punctuation or space
+func words(term string) []string {
+	return strings.FieldsFunc(term, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// normalize lower cases s and drops everything but letters and digits
+func normalize(s string) string {
+	return strings.ToLower(strings.Join(words(s), ""))
+}
+
+// searchCondition requires every term to appear in one of the search fields. The words of a term
+// must appear in order, so "red wing" finds both "Red Wing Shoes" and "redwingshoes.com", and "bob's"
+// finds "Bob's" and "bob-s". SOQL cannot say that only space or punctuation may sit between the
+// words, so the results are narrowed further by matchesPhrases. A single term may also be a tracking
+// code.
+func searchCondition(terms []string) condition {
+	conditions := []condition{}
+	for _, term := range terms {
+		termWords := words(term)
+		if len(termWords) == 0 {
+			continue
+		}
+		matches := []condition{}
+		for _, field := range searchFields {
+			matches = append(matches, containsInOrder(field, termWords))
+		}
+		conditions = append(conditions, or(matches...))
+	}
+	if len(terms) != 1 && len(conditions) > 0 {
+		return and(conditions...)
+	}
+	trackingCode := equals("Tracking_Code__c", strings.Join(terms, " "))
+	if len(conditions) == 0 {
+		return trackingCode
+	}
+	return or(and(conditions...), trackingCode)
+}
+
+// matchesPhrases reports whether the words of every term sit next to each other in one of the search
+// fields, with nothing but space or punctuation between them, so "red wing" does not find "Redmond
+// Winguard". An account whose tracking code is the search always matches.
+func matchesPhrases(account *Account, terms []string) bool {
+	if len(terms) == 1 && account.TrackingCode == terms[0] {
+		return true
+	}
+	for _, term := range terms {
+		termWords := words(term)
+		if len(termWords) < 2 {
+			continue
+		}
+		phrase := normalize(term)
+		found := false
+		for _, value := range []string{account.Website, account.Name, account.Platform} {
+			if strings.Contains(normalize(value), phrase) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// match quality, best first
+const (
+	matchExact = iota
+	matchPrefix
+	matchPhrase
+	matchTerms
+)
+
+// matchQuality scores how well an account matches the search, lower is better
+func matchQuality(account *Account, search string) int {
+	query := normalize(search)
+	best := matchTerms
+	for _, value := range []string{siteName(account.Website), account.Name} {
+		value = normalize(value)
+		if value == "" || query == "" {
+			continue
+		}
+		quality := matchTerms
+		switch {
+		case value == query:
+			quality = matchExact
+		case strings.HasPrefix(value, query):
+			quality = matchPrefix
+		case strings.Contains(value, query):
+			quality = matchPhrase
+		}
+		if quality < best {
+			best = quality
+		}
+	}
+	return best
+}
+
+// siteName strips the top level domain so that "shoes" is an exact match for "shoes.com"
+func siteName(website string) string {
+	if i := strings.LastIndex(website, "."); i > 0 {
+		return website[:i]
+	}
+	return website
+}
+
+// rankAccounts orders accounts by match quality, then by the shortest website. Accounts that are
+// equally good keep their MRR order.
+func rankAccounts(accounts []*Account, search string) []*Account {
+	quality := map[*Account]int{}
+	for _, account := range accounts {
+		quality[account] = matchQuality(account, search)
+	}
+	sort.SliceStable(accounts, func(i, j int) bool {
+		if quality[accounts[i]] != quality[accounts[j]] {
+			return quality[accounts[i]] < quality[accounts[j]]
+		}
+		return len(accounts[i].Website) < len(accounts[j].Website)
+	})
+	return accounts
+}
@@ -0,0 +1,65 @@
+package salesforce
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSearch(t *testing.T) {
+	require.Equal(t, []string{"red", "wing", "shoes"}, parseSearch("red wing  shoes"))
+	require.Equal(t, []string{"red wing", "shoes"}, parseSearch(`"red  wing" shoes`))
+	require.Equal(t, []string{"bob's shoes"}, parseSearch(`“bob's shoes”`))
+	require.Equal(t, []string{"shoes", "red wing"}, parseSearch(`shoes "red wing`))
+	require.Equal(t, []string{}, parseSearch(`  "" `))
+}
+
+func TestNormalize(t *testing.T) {
+	require.Equal(t, "bobsshoes", normalize("Bob's Shoes"))
+	require.Equal(t, "ab", normalize("A&B"))
+	require.Equal(t, "redwingshoescom", normalize("redwingshoes.com"))
+}
+
+func TestTokenize(t *testing.T) {
+	require.Equal(t, []string{"platform:magento", `rep:"Ashley Hilton"`, "mrr>1000", `"red  wing"`}, tokenize(`platform:magento rep:"Ashley Hilton"  mrr>1000 "red  wing"`))
+}
+
+func TestSearchCondition(t *testing.T) {
+	require.Equal(t, condition("((Website LIKE '%red%' OR Name LIKE '%red%' OR Platform__c LIKE '%red%') AND "+
+		"(Website LIKE '%wing%' OR Name LIKE '%wing%' OR Platform__c LIKE '%wing%'))"),
+		searchCondition([]string{"red", "wing"}))
+	require.Equal(t, condition("((Website LIKE '%bob%s%shoes%' OR Name LIKE '%bob%s%shoes%' OR Platform__c LIKE '%bob%s%shoes%') "+
+		`OR Tracking_Code__c = 'bob\'s shoes')`),
+		searchCondition([]string{"bob's shoes"}))
+	require.Equal(t, condition("Tracking_Code__c = '&'"), searchCondition([]string{"&"}))
+}
+
+func TestMatchesPhrases(t *testing.T) {
+	terms := []string{"red wing", "shoes"}
+	require.True(t, matchesPhrases(&Account{Website: "redwingshoes.com", Name: "RWS"}, terms))
+	require.True(t, matchesPhrases(&Account{Website: "shoes.com", Name: "Red-Wing Group"}, terms))
+	require.False(t, matchesPhrases(&Account{Website: "shoes.com", Name: "Redmond Winguard"}, terms))
+	require.True(t, matchesPhrases(&Account{Name: "Redmond Winguard", TrackingCode: "red wing"}, []string{"red wing"}))
+}
+
+func TestRankAccounts(t *testing.T) {
+	accounts := []*Account{
+		{Website: "bestredwingshoesdeals.com", Name: "Deals Inc"},
+		{Website: "redwing.co.uk", Name: "Red Wing UK"},
+		{Website: "redwingshoes.com", Name: "Red Wing Shoes"},
+		{Website: "shoes.com", Name: "Red Wing Group"},
+		{Website: "redwingshoestore.com", Name: "Red Wing Shoe Store"},
+	}
+	ranked := rankAccounts(accounts, "red wing shoes")
+	websites := []string{}
+	for _, a := range ranked {
+		websites = append(websites, a.Website)
+	}
+	require.Equal(t, []string{
+		"redwingshoes.com",
+		"redwingshoestore.com",
+		"bestredwingshoesdeals.com",
+		"shoes.com",
+		"redwing.co.uk",
+	}, websites)
+}
@@ -0,0 +1,381 @@
+package salesforce
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nlopes/slack"
+	"github.com/searchspring/nebo/render"
+	"github.com/simpleforce/simpleforce"
+)
+
+// ShowPrefix starts a /nebo search that shows one account in detail
+const ShowPrefix = "show "
+
+// the account fields shown in detail, on top of the ones in search results
+var detailFields = append(append([]string{}, accountFields...),
+	"Owner.Name",
+	"OwnerId",
+	"ParentId",
+)
+
+const relatedLimit = 5
+
+// candidateLimit is how many partly matching accounts are suggested when none matches exactly
+const candidateLimit = 5
+
+// familyLimit is how many of a family's accounts are listed, a big family would not fit in a section
+const familyLimit = 10
+
+// detail is everything shown about one account
+type detail struct {
+	Account       *Account
+	Owner         string
+	OwnerID       string
+	ParentID      string
+	Dates         []dateField
+	Cases         []simpleforce.SObject
+	Opportunities []simpleforce.SObject
+	Contacts      []simpleforce.SObject
+	Family        []*Account
+	FamilySize    int
+}
+
+// dateField is one of the date fields each org names differently, shown if it has been configured
+type dateField struct {
+	Label string
+	Field string
+	Value string
+}
+
+// dateFields are the configured date fields, in the order they are shown
+func (s *DAOImpl) dateFields() []dateField {
+	fields := []dateField{}
+	for _, f := range []dateField{{"Contract start", s.ContractStartField, ""}, {"Renewal", s.RenewalField, ""}} {
+		if f.Field != "" {
+			fields = append(fields, f)
+		}
+	}
+	return fields
+}
+
+// Show finds the account with the tracking code or website and shows it with its open cases,
+// recent opportunities, contacts and family of parent and child accounts
+func (s *DAOImpl) Show(term string) ([]byte, error) {
+	term = strings.TrimSpace(term)
+	if term == "" {
+		return json.Marshal(render.Ephemeral("Tell me which account to show, e.g. `/nebo show shoes.com` or `/nebo show m6umjp`"))
+	}
+	d, candidates, err := s.findDetail(term)
+	if err != nil {
+		return nil, err
+	}
+	if d == nil {
+		return json.Marshal(notFound(term, candidates))
+	}
+
+	related := []struct {
+		into *[]simpleforce.SObject
+		q    *soqlQuery
+	}{
+		{&d.Cases, casesQuery(d.Account.ID)},
+		{&d.Opportunities, opportunitiesQuery(d.Account.ID)},
+		{&d.Contacts, contactsQuery(d.Account.ID)},
+	}
+	for _, r := range related {
+		result, err := s.query(r.q.String())
+		if err != nil {
+			return nil, err
+		}
+		*r.into = result.Records
+	}
+
+	family, err := s.query(familyQuery(d.familyRoot()).String())
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range family.Records {
+		d.Family = append(d.Family, toAccount(record))
+	}
+	d.Family = cleanAccounts(d.Family)
+	d.FamilySize = len(d.Family)
+	if d.FamilySize >= familyLimit {
+		count, err := s.query(familyCountQuery(d.familyRoot()).String())
+		if err != nil {
+			return nil, err
+		}
+		d.FamilySize = count.TotalSize
+	}
+	return json.Marshal(d.message(s.URL))
+}
+
+// findDetail loads the account whose tracking code or website is exactly the term, a tracking code
+// beating a website. If there isn't one, the accounts whose website only contains the term are
+// returned instead so the user can pick one.
+func (s *DAOImpl) findDetail(term string) (*detail, []*Account, error) {
+	site := normalizeWebsite(term)
+	match := equals("Tracking_Code__c", term)
+	if site != "" {
+		match = or(match, contains("Website", site))
+	}
+	fields := append([]string{}, detailFields...)
+	dates := s.dateFields()
+	for _, f := range dates {
+		fields = append(fields, f.Field)
+	}
+	q := selectFields(fields...).
+		from("Account").
+		where(match).
+		orderBy("Chargify_MRR__c", true).
+		limit(20)
+	result, err := s.query(q.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	best := -1
+	bestScore := 0
+	candidates := []*Account{}
+	for i, record := range result.Records {
+		account := cleanAccounts([]*Account{toAccount(record)})[0]
+		score := 0
+		switch {
+		case account.TrackingCode == term:
+			score = 2
+		case site != "" && strings.EqualFold(account.Website, site):
+			score = 1
+		}
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+		if len(candidates) < candidateLimit {
+			candidates = append(candidates, account)
+		}
+	}
+	if best < 0 {
+		return nil, candidates, nil
+	}
+	record := result.Records[best]
+	d := &detail{
+		Account:  cleanAccounts([]*Account{toAccount(record)})[0],
+		Owner:    relatedName(record, "Owner"),
+		OwnerID:  stringField(record, "OwnerId"),
+		ParentID: stringField(record, "ParentId"),
+	}
+	for _, f := range dates {
+		f.Value = stringField(record, f.Field)
+		d.Dates = append(d.Dates, f)
+	}
+	return d, nil, nil
+}
+
+// notFound says no account is exactly the term, listing the accounts that partly match it
+func notFound(term string, candidates []*Account) *slack.Msg {
+	text := "I couldn't find an account with the tracking code or website `" + term + "`"
+	if len(candidates) == 0 {
+		return render.Ephemeral(text)
+	}
+	lines := []string{}
+	for _, a := range candidates {
+		line := a.Website
+		if line == "" {
+			line = a.Name
+		}
+		if a.TrackingCode != "" {
+			line += " · `" + a.TrackingCode + "`"
+		}
+		lines = append(lines, "• "+line)
+	}
+	return render.Ephemeral(text + ", did you mean one of these?\n" + strings.Join(lines, "\n"))
+}
+
+// familyRoot is the top of the account's family, its parent if it has one
+func (d *detail) familyRoot() string {
+	if d.ParentID != "" {
+		return d.ParentID
+	}
+	return d.Account.ID
+}
+
+func casesQuery(accountID string) *soqlQuery {
+	return selectFields("Id", "CaseNumber", "Subject", "Status", "Priority", "CreatedDate").
+		from("Case").
+		where(equals("AccountId", accountID), equals("IsClosed", false)).
+		orderBy("CreatedDate", true).
+		limit(relatedLimit)
+}
+
+func opportunitiesQuery(accountID string) *soqlQuery {
+	return selectFields("Id", "Name", "StageName", "Amount", "CloseDate").
+		from("Opportunity").
+		where(equals("AccountId", accountID)).
+		orderBy("CloseDate", true).
+		limit(relatedLimit)
+}
+
+func contactsQuery(accountID string) *soqlQuery {
+	return selectFields("Id", "Name", "Title", "Email").
+		from("Contact").
+		where(equals("AccountId", accountID)).
+		orderBy("Name", false).
+		limit(relatedLimit)
+}
+
+func familyQuery(rootID string) *soqlQuery {
+	return selectFields(accountFields...).
+		from("Account").
+		where(familyCondition(rootID)).
+		orderBy("Chargify_MRR__c", true).
+		limit(familyLimit)
+}
+
+func familyCountQuery(rootID string) *soqlQuery {
+	return selectFields("COUNT()").
+		from("Account").
+		where(familyCondition(rootID))
+}
+
+func familyCondition(rootID string) condition {
+	return or(equals("Id", rootID), equals("ParentId", rootID))
+}
+
+// message lays the detail out as a section per kind of record
+func (d *detail) message(sfURL string) *slack.Msg {
+	a := d.Account
+	heading := a.Website
+	if heading == "" {
+		heading = a.Name
+	}
+	title := "*" + link(sfURL, a.ID, heading) + "* (" + a.Active + ")"
+	if a.Name != "" && a.Name != heading {
+		title += "\n" + a.Name
+	}
+	fields := []*slack.TextBlockObject{
+		field("Account owner", link(sfURL, d.OwnerID, orUnknown(d.Owner))),
+		field("CS manager", link(sfURL, a.ManagerID, a.Manager)),
+		field("MRR", FormatMoney(a.MRR)),
+		field("Family MRR", FormatMoney(a.FamilyMRR)),
+	}
+	for _, f := range d.Dates {
+		fields = append(fields, field(f.Label, orUnknown(f.Value)))
+	}
+	fields = append(fields,
+		field("Platform", a.Platform),
+		field("Tracking code", orUnknown(a.TrackingCode)),
+	)
+	msg := &slack.Msg{
+		ResponseType: slack.ResponseTypeInChannel,
+		Text:         "Account details for " + heading,
+	}
+	msg.Blocks.BlockSet = []slack.Block{
+		slack.NewSectionBlock(render.Markdown(title), fields, nil),
+		slack.NewDividerBlock(),
+		section("Open cases", d.Cases, func(r simpleforce.SObject) string {
+			return link(sfURL, r.ID(), stringField(r, "CaseNumber")) + " " + stringField(r, "Subject") +
+				" · " + stringField(r, "Status") + " · " + stringField(r, "Priority") + " · opened " + date(stringField(r, "CreatedDate"))
+		}),
+		section("Recent opportunities", d.Opportunities, func(r simpleforce.SObject) string {
+			return link(sfURL, r.ID(), stringField(r, "Name")) + " · " + stringField(r, "StageName") +
+				" · " + FormatMoney(floatField(r, "Amount")) + " · closes " + date(stringField(r, "CloseDate"))
+		}),
+		section("Contacts", d.Contacts, func(r simpleforce.SObject) string {
+			line := link(sfURL, r.ID(), stringField(r, "Name"))
+			for _, extra := range []string{stringField(r, "Title"), stringField(r, "Email")} {
+				if extra != "" {
+					line += " · " + extra
+				}
+			}
+			return line
+		}),
+		d.familySection(sfURL),
+	}
+	return msg
+}
+
+func (d *detail) familySection(sfURL string) slack.Block {
+	lines := []string{}
+	for _, member := range d.Family {
+		line := link(sfURL, member.ID, member.Website) + " · " + FormatMoney(member.MRR)
+		switch {
+		case member.ID == d.Account.ID:
+			line += " · this account"
+		case member.ID == d.ParentID:
+			line += " · parent"
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) <= 1 {
+		lines = []string{"no parent or child accounts"}
+	}
+	if more := d.FamilySize - len(d.Family); more > 0 {
+		lines = append(lines, fmt.Sprintf("and %d more", more))
+	}
+	return slack.NewSectionBlock(render.Markdown("*Family*\n"+strings.Join(lines, "\n")), nil, nil)
+}
+
+func section(heading string, records []simpleforce.SObject, line func(simpleforce.SObject) string) slack.Block {
+	lines := []string{}
+	for _, record := range records {
+		lines = append(lines, "• "+line(record))
+	}
+	if len(lines) == 0 {
+		lines = []string{"none"}
+	}
+	return slack.NewSectionBlock(render.Markdown(fmt.Sprintf("*%s*\n%s", heading, strings.Join(lines, "\n"))), nil, nil)
+}
+
+// link shows the text as a link to the record, or as it is if the record or instance is not known
+func link(sfURL string, id string, text string) string {
+	if sfURL == "" || id == "" {
+		return text
+	}
+	return "<" + recordURL(sfURL, id) + "|" + text + ">"
+}
+
+func field(label string, value string) *slack.TextBlockObject {
+	return render.Markdown("*" + label + ":*\n" + value)
+}
+
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
+
+// date keeps the day of a salesforce date or datetime, e.g. 2020-10-01T12:00:00.000+0000 is 2020-10-01
+func date(value string) string {
+	if len(value) > len("2006-01-02") {
+		return value[:len("2006-01-02")]
+	}
+	return orUnknown(value)
+}
+
+func stringField(record simpleforce.SObject, name string) string {
+	if value, ok := record[name].(string); ok {
+		return value
+	}
+	return ""
+}
+
+func floatField(record simpleforce.SObject, name string) float64 {
+	if value, ok := record[name].(float64); ok {
+		return value
+	}
+	return -1
+}
+
+// relatedName is the name of a record the record looks up, e.g. the Owner of an account
+func relatedName(record simpleforce.SObject, relation string) string {
+	if related, ok := record[relation].(map[string]interface{}); ok {
+		if name, ok := related["Name"].(string); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// normalizeWebsite strips the scheme, www. and trailing slash so a pasted url matches the website
+func normalizeWebsite(term string) string {
+	return cleanAccounts([]*Account{{Website: strings.ToLower(term)}})[0].Website
+}
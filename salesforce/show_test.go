@@ -0,0 +1,157 @@
+package salesforce
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nlopes/slack"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeAccountRecords answers each SOQL query with the records for the object it selects from
+func fakeAccountRecords(t *testing.T, queries *[]string) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/services/Soap/u/") {
+			w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+				<soapenv:Envelope xmlns:soapenv="http://schemas.xmlsoap.org/soap/envelope/"><soapenv:Body><loginResponse><result>
+				<serverUrl>` + server.URL + `/services/Soap/u/43.0/00D</serverUrl>
+				<sessionId>session</sessionId>
+				</result></loginResponse></soapenv:Body></soapenv:Envelope>`))
+			return
+		}
+		q := r.URL.Query().Get("q")
+		*queries = append(*queries, q)
+		records := `[]`
+		switch {
+		case strings.Contains(q, "FROM Case"):
+			records = `[{"Id":"500A","CaseNumber":"00001234","Subject":"Search is slow","Status":"New","Priority":"High","CreatedDate":"2020-10-01T12:00:00.000+0000"}]`
+		case strings.Contains(q, "FROM Opportunity"):
+			records = `[{"Id":"006A","Name":"Fabletics renewal","StageName":"Negotiation","Amount":12000,"CloseDate":"2020-12-31"}]`
+		case strings.Contains(q, "FROM Contact"):
+			records = `[{"Id":"003A","Name":"Pat Doe","Title":"CTO","Email":"pat@fabletics.com"},{"Id":"003B","Name":"Sam Roe","Title":null,"Email":null}]`
+		case strings.Contains(q, "ParentId = '001P'"):
+			records = `[
+				{"Id":"001P","Type":"Customer","Name":"TechStyle","Website":"techstyle.com","Chargify_MRR__c":900},
+				{"Id":"001A","Type":"Customer","Name":"Fabletics","Website":"fabletics.com","Chargify_MRR__c":3955.17}
+			]`
+		case strings.Contains(q, "FROM Account"):
+			records = `[
+				{"Id":"001B","Type":"Customer","Name":"Fabletics Outlet","Website":"outlet.fabletics.com","Tracking_Code__c":"zzz999","Chargify_MRR__c":5000},
+				{"Id":"001A","Type":"Customer","Name":"Fabletics","Website":"https://www.fabletics.com/","Tracking_Code__c":"m6umjp",
+				 "CS_Manager__c":"005M","CS_Manager__r":{"Name":"Ashley Hilton"},"Owner":{"Name":"Jo Sales"},"OwnerId":"005O",
+				 "ParentId":"001P","Chargify_MRR__c":3955.17,"Family_MRR__c":14858.54,"Platform__c":"Custom",
+				 "Contract_Start_Date__c":"2019-01-01","Renewal_Date__c":"2021-01-01"}
+			]`
+		}
+		w.Write([]byte(`{"totalSize":1,"done":true,"records":` + records + `}`))
+	}))
+	return server
+}
+
+func showMessage(t *testing.T, term string) (*slack.Msg, []string) {
+	return showMessageWithDates(t, term, "Contract_Start_Date__c", "Renewal_Date__c")
+}
+
+func showMessageWithDates(t *testing.T, term string, contractStartField string, renewalField string) (*slack.Msg, []string) {
+	queries := []string{}
+	server := fakeAccountRecords(t, &queries)
+	defer server.Close()
+	dao := NewDAO(server.URL, "user", "password", "token", contractStartField, renewalField)
+	require.NotNil(t, dao)
+	response, err := dao.Show(term)
+	require.Nil(t, err)
+	msg := &slack.Msg{}
+	require.Nil(t, json.Unmarshal(response, msg))
+	return msg, queries
+}
+
+func TestShowRunsAQueryPerRelatedObject(t *testing.T) {
+	_, queries := showMessage(t, "https://www.fabletics.com/")
+	require.Len(t, queries, 5)
+	require.Contains(t, queries[0], "FROM Account WHERE (Tracking_Code__c = 'https://www.fabletics.com/' OR Website LIKE '%fabletics.com%')")
+	require.Contains(t, queries[1], "FROM Case WHERE AccountId = '001A' AND IsClosed = false ORDER BY CreatedDate DESC LIMIT 5")
+	require.Contains(t, queries[2], "FROM Opportunity WHERE AccountId = '001A' ORDER BY CloseDate DESC LIMIT 5")
+	require.Contains(t, queries[3], "FROM Contact WHERE AccountId = '001A' ORDER BY Name LIMIT 5")
+	require.Contains(t, queries[4], "FROM Account WHERE (Id = '001P' OR ParentId = '001P') ORDER BY Chargify_MRR__c DESC LIMIT 10")
+}
+
+func TestShowLaysOutSections(t *testing.T) {
+	msg, _ := showMessage(t, "m6umjp")
+	require.Equal(t, slack.ResponseTypeInChannel, msg.ResponseType)
+	require.Len(t, msg.Blocks.BlockSet, 6)
+
+	header := msg.Blocks.BlockSet[0].(*slack.SectionBlock)
+	require.True(t, strings.HasPrefix(header.Text.Text, "*<"))
+	require.Contains(t, header.Text.Text, "/001A|fabletics.com>* (Active)\nFabletics")
+	fields := []string{}
+	for _, f := range header.Fields {
+		fields = append(fields, f.Text)
+	}
+	require.Contains(t, fields[0], "/005O|Jo Sales>")
+	require.Contains(t, fields[1], "/005M|Ashley Hilton>")
+	require.Equal(t, "*Family MRR:*\n$14858.54", fields[3])
+	require.Equal(t, "*Contract start:*\n2019-01-01", fields[4])
+	require.Equal(t, "*Renewal:*\n2021-01-01", fields[5])
+
+	text := func(i int) string { return msg.Blocks.BlockSet[i].(*slack.SectionBlock).Text.Text }
+	require.Contains(t, text(2), "*Open cases*\n• <")
+	require.Contains(t, text(2), "|00001234> Search is slow · New · High · opened 2020-10-01")
+	require.Contains(t, text(3), "|Fabletics renewal> · Negotiation · $12000.00 · closes 2020-12-31")
+	require.Contains(t, text(4), "|Pat Doe> · CTO · pat@fabletics.com\n• <")
+	require.True(t, strings.HasSuffix(text(4), "|Sam Roe>"))
+	require.Contains(t, text(5), "|techstyle.com> · $900.00 · parent\n")
+	require.Contains(t, text(5), "|fabletics.com> · $3955.17 · this account")
+}
+
+func TestShowLeavesOutDateFieldsThatAreNotConfigured(t *testing.T) {
+	msg, queries := showMessageWithDates(t, "m6umjp", "", "Renewal_Date__c")
+	require.NotContains(t, queries[0], "Contract_Start_Date__c")
+	require.Contains(t, queries[0], ", Renewal_Date__c FROM Account")
+	fields := msg.Blocks.BlockSet[0].(*slack.SectionBlock).Fields
+	require.Len(t, fields, 7)
+	require.Equal(t, "*Renewal:*\n2021-01-01", fields[4].Text)
+
+	msg, queries = showMessageWithDates(t, "m6umjp", "", "")
+	require.NotContains(t, queries[0], "Renewal_Date__c")
+	fields = msg.Blocks.BlockSet[0].(*slack.SectionBlock).Fields
+	require.Len(t, fields, 6)
+	require.Equal(t, "*Platform:*\nCustom", fields[4].Text)
+}
+
+func TestShowPrefersTrackingCode(t *testing.T) {
+	msg, _ := showMessage(t, "zzz999")
+	require.Contains(t, msg.Text, "outlet.fabletics.com")
+}
+
+func TestShowListsCandidatesForAPartialWebsite(t *testing.T) {
+	msg, queries := showMessage(t, "fabletics")
+	require.Len(t, queries, 1)
+	require.Equal(t, slack.ResponseTypeEphemeral, msg.ResponseType)
+	require.Equal(t, "I couldn't find an account with the tracking code or website `fabletics`, did you mean one of these?\n"+
+		"• outlet.fabletics.com · `zzz999`\n• fabletics.com · `m6umjp`", msg.Text)
+	require.Empty(t, msg.Blocks.BlockSet)
+	require.Equal(t, "I couldn't find an account with the tracking code or website `shoes`", notFound("shoes", nil).Text)
+}
+
+func TestShowEmptySections(t *testing.T) {
+	d := &detail{Account: &Account{ID: "001A", Website: "shoes.com", Active: "Active", MRR: -1, FamilyMRR: -1}}
+	msg := d.message("")
+	require.Equal(t, "*shoes.com* (Active)", msg.Blocks.BlockSet[0].(*slack.SectionBlock).Text.Text)
+	require.Equal(t, "*Open cases*\nnone", msg.Blocks.BlockSet[2].(*slack.SectionBlock).Text.Text)
+	require.Equal(t, "*Family*\nno parent or child accounts", msg.Blocks.BlockSet[5].(*slack.SectionBlock).Text.Text)
+}
+
+func TestShowSaysHowManyMoreAreInTheFamily(t *testing.T) {
+	d := &detail{Account: &Account{ID: "001A", Website: "shoes.com", Active: "Active", MRR: -1, FamilyMRR: -1}, FamilySize: 25}
+	for i := 0; i < familyLimit; i++ {
+		d.Family = append(d.Family, &Account{ID: fmt.Sprintf("001%d", i), Website: fmt.Sprintf("shoes%d.com", i), MRR: 10})
+	}
+	family := d.message("").Blocks.BlockSet[5].(*slack.SectionBlock).Text.Text
+	require.True(t, strings.HasSuffix(family, "\nshoes9.com · $10.00\nand 15 more"), family)
+	require.Equal(t, "SELECT COUNT() FROM Account WHERE (Id = '001P' OR ParentId = '001P')", familyCountQuery("001P").String())
+}
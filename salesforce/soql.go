@@ -0,0 +1,169 @@
+package salesforce
+
+import (
+	"strconv"
+	"strings"
+)
+
+// condition is a rendered SOQL boolean expression. Conditions are only built by the helpers below
+// so that every value in them has been escaped.
+type condition string
+
+// soqlQuery builds a SELECT statement. Object and field names are taken from code, values always go
+// through literal so user input can never change the structure of the query.
+type soqlQuery struct {
+	fields     []string
+	object     string
+	conditions []condition
+	order      []string
+	rowLimit   int
+	rowOffset  int
+}
+
+func selectFields(fields ...string) *soqlQuery {
+	return &soqlQuery{fields: fields}
+}
+
+func (q *soqlQuery) from(object string) *soqlQuery {
+	q.object = object
+	return q
+}
+
+// where adds conditions that must all hold
+func (q *soqlQuery) where(conditions ...condition) *soqlQuery {
+	q.conditions = append(q.conditions, conditions...)
+	return q
+}
+
+func (q *soqlQuery) orderBy(field string, descending bool) *soqlQuery {
+	if descending {
+		field += " DESC"
+	}
+	q.order = append(q.order, field)
+	return q
+}
+
+func (q *soqlQuery) limit(limit int) *soqlQuery {
+	q.rowLimit = limit
+	return q
+}
+
+func (q *soqlQuery) offset(offset int) *soqlQuery {
+	q.rowOffset = offset
+	return q
+}
+
+func (q *soqlQuery) String() string {
+	soql := "SELECT " + strings.Join(q.fields, ", ") + " FROM " + q.object
+	if len(q.conditions) > 0 {
+		parts := make([]string, len(q.conditions))
+		for i, c := range q.conditions {
+			parts[i] = string(c)
+		}
+		soql += " WHERE " + strings.Join(parts, " AND ")
+	}
+	if len(q.order) > 0 {
+		soql += " ORDER BY " + strings.Join(q.order, ", ")
+	}
+	if q.rowLimit > 0 {
+		soql += " LIMIT " + strconv.Itoa(q.rowLimit)
+	}
+	if q.rowOffset > 0 {
+		soql += " OFFSET " + strconv.Itoa(q.rowOffset)
+	}
+	return soql
+}
+
+func and(conditions ...condition) condition {
+	return join(" AND ", conditions)
+}
+
+func or(conditions ...condition) condition {
+	return join(" OR ", conditions)
+}
+
+func join(operator string, conditions []condition) condition {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	parts := make([]string, len(conditions))
+	for i, c := range conditions {
+		parts[i] = string(c)
+	}
+	return condition("(" + strings.Join(parts, operator) + ")")
+}
+
+// compare renders field <operator> value, operator must be one of = != < <= > >=
+func compare(field string, operator string, value interface{}) condition {
+	return condition(field + " " + operator + " " + literal(value))
+}
+
+func equals(field string, value interface{}) condition {
+	return compare(field, "=", value)
+}
+
+func in(field string, values ...string) condition {
+	literals := make([]string, len(values))
+	for i, v := range values {
+		literals[i] = literal(v)
+	}
+	return condition(field + " IN (" + strings.Join(literals, ", ") + ")")
+}
+
+// contains matches the value anywhere in the field, wildcards in the value are matched literally
+func contains(field string, value string) condition {
+	return condition(field + " LIKE '%" + escapeLike(value) + "%'")
+}
+
+// containsInOrder matches the words in order with anything between them, which is as close as LIKE
+// gets to matching a phrase
+func containsInOrder(field string, words []string) condition {
+	escaped := make([]string, len(words))
+	for i, w := range words {
+		escaped[i] = escapeLike(w)
+	}
+	return condition(field + " LIKE '%" + strings.Join(escaped, "%") + "%'")
+}
+
+// literal renders a value as a SOQL literal
+func literal(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return "'" + escapeString(v) + "'"
+	default:
+		panic("unsupported soql literal")
+	}
+}
+
+// https://developer.salesforce.com/docs/atlas.en-us.soql_sosl.meta/soql_sosl/sforce_api_calls_soql_select_quotedstringescapes.htm
+var stringEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+	"\b", `\b`,
+	"\f", `\f`,
+)
+
+var likeEscaper = strings.NewReplacer(
+	`%`, `\%`,
+	`_`, `\_`,
+)
+
+func escapeString(s string) string {
+	return stringEscaper.Replace(s)
+}
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(escapeString(s))
+}
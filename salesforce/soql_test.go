@@ -0,0 +1,87 @@
+package salesforce
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		value    interface{}
+		expected string
+	}{
+		{"shoes.com", `'shoes.com'`},
+		{"Bob's Shoes", `'Bob\'s Shoes'`},
+		{"A&B", `'A&B'`},
+		{`back\slash`, `'back\\slash'`},
+		{`"quoted"`, `'\"quoted\"'`},
+		{"new\nline", `'new\nline'`},
+		{1000.5, `1000.5`},
+		{42, `42`},
+		{true, `true`},
+		{nil, `null`},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.expected, literal(test.value))
+	}
+}
+
+func TestContainsEscapesWildcards(t *testing.T) {
+	require.Equal(t, condition(`Website LIKE '%100\% cotton%'`), contains("Website", "100% cotton"))
+	require.Equal(t, condition(`Website LIKE '%my\_store%'`), contains("Website", "my_store"))
+	require.Equal(t, condition(`Website LIKE '%Bob\'s%'`), contains("Website", "Bob's"))
+}
+
+func TestQueryBuilder(t *testing.T) {
+	q := selectFields("Id", "Website").
+		from("Account").
+		where(in("Type", "Customer", "Inactive Customer")).
+		where(or(contains("Website", "shoes"), equals("Tracking_Code__c", "shoes"))).
+		orderBy("Chargify_MRR__c", true).
+		limit(20).
+		offset(40)
+	require.Equal(t, "SELECT Id, Website FROM Account WHERE Type IN ('Customer', 'Inactive Customer') "+
+		"AND (Website LIKE '%shoes%' OR Tracking_Code__c = 'shoes') ORDER BY Chargify_MRR__c DESC LIMIT 20 OFFSET 40", q.String())
+}
+
+func TestHostileInputCannotChangeQueryStructure(t *testing.T) {
+	build := func(search string) string {
+		return accountQuery().where(contains("Website", search), equals("Tracking_Code__c", search)).String()
+	}
+	expected := skeleton(build("shoes"))
+	for _, hostile := range []string{
+		`' OR Name != '`,
+		`%' OR Type != '`,
+		`\' OR Id != null OR Website = \'`,
+		`\\' OR Id != null) --`,
+		`x') OR (Id != null`,
+		"'\n OR Id != null",
+		`Bob's Shoes`,
+		`A&B`,
+		`%_%`,
+	} {
+		require.Equal(t, expected, skeleton(build(hostile)), hostile)
+	}
+}
+
+// skeleton replaces every quoted literal with ? so that only the query structure remains
+func skeleton(soql string) string {
+	out := []byte{}
+	quoted := false
+	for i := 0; i < len(soql); i++ {
+		c := soql[i]
+		switch {
+		case quoted && c == '\\':
+			i++
+		case c == '\'':
+			if !quoted {
+				out = append(out, '?')
+			}
+			quoted = !quoted
+		case !quoted:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,129 @@
+package slackauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxAge is how far a request timestamp may drift from now before it is treated as a replay
+const DefaultMaxAge = 5 * time.Minute
+
+const signatureHeader = "X-Slack-Signature"
+const timestampHeader = "X-Slack-Request-Timestamp"
+const signatureVersion = "v0"
+
+var (
+	// ErrMissingHeaders is returned when a request carries no signature and no token fallback is allowed
+	ErrMissingHeaders = errors.New("missing slack signature headers")
+	// ErrInvalidTimestamp is returned when the request timestamp is not a unix time
+	ErrInvalidTimestamp = errors.New("invalid slack request timestamp")
+	// ErrExpiredTimestamp is returned when the request timestamp is outside the replay window
+	ErrExpiredTimestamp = errors.New("slack request timestamp outside the replay window")
+	// ErrInvalidSignature is returned when the signature does not match the body
+	ErrInvalidSignature = errors.New("invalid slack signature")
+	// ErrInvalidToken is returned when the legacy verification token does not match
+	ErrInvalidToken = errors.New("invalid slack verification token")
+)
+
+// Verifier checks that incoming requests were sent by Slack
+type Verifier struct {
+	SigningSecret     string
+	VerificationToken string
+	MaxAge            time.Duration
+	Now               func() time.Time
+}
+
+// NewVerifier returns a verifier for the signing secret. If verificationToken is not blank, requests
+// without signature headers are checked against the legacy verification token instead.
+func NewVerifier(signingSecret string, verificationToken string) *Verifier {
+	return &Verifier{
+		SigningSecret:     signingSecret,
+		VerificationToken: verificationToken,
+		MaxAge:            DefaultMaxAge,
+		Now:               time.Now,
+	}
+}
+
+// Verify authenticates the request, leaving the body in place for later parsing
+func (v *Verifier) Verify(r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	r.Body = ioutil.NopCloser(bytes.NewBuffer(body))
+
+	signature := r.Header.Get(signatureHeader)
+	stimestamp := r.Header.Get(timestampHeader)
+	if signature == "" && stimestamp == "" && v.VerificationToken != "" {
+		return v.verifyToken(body)
+	}
+	if signature == "" || stimestamp == "" || v.SigningSecret == "" {
+		return ErrMissingHeaders
+	}
+	return v.verifySignature(signature, stimestamp, body)
+}
+
+func (v *Verifier) verifySignature(signature string, stimestamp string, body []byte) error {
+	timestamp, err := strconv.ParseInt(stimestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	age := v.Now().Sub(time.Unix(timestamp, 0))
+	if age < 0 {
+		age = -age
+	}
+	if age > v.MaxAge {
+		return ErrExpiredTimestamp
+	}
+
+	if !strings.HasPrefix(signature, signatureVersion+"=") {
+		return ErrInvalidSignature
+	}
+	expected, err := hex.DecodeString(strings.TrimPrefix(signature, signatureVersion+"="))
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(expected, Sign(v.SigningSecret, stimestamp, body)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+func (v *Verifier) verifyToken(body []byte) error {
+	values, err := url.ParseQuery(string(body))
+	if err != nil {
+		return ErrInvalidToken
+	}
+	token := values.Get("token")
+	if payload := values.Get("payload"); token == "" && payload != "" {
+		interaction := &struct {
+			Token string `json:"token"`
+		}{}
+		json.Unmarshal([]byte(payload), interaction)
+		token = interaction.Token
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(v.VerificationToken)) != 1 {
+		return ErrInvalidToken
+	}
+	return nil
+}
+
+// Sign returns the v0 HMAC-SHA256 of the timestamp and body using the signing secret
+func Sign(signingSecret string, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(signatureVersion + ":" + timestamp + ":"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,74 @@
+package slackauth
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// recorded from https://api.slack.com/authentication/verifying-requests-from-slack
+const recordedSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+const recordedTimestamp = "1531420618"
+const recordedSignature = "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+const recordedBody = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+const forgedBody = "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fnebo&text=shoes&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+
+func TestVerify(t *testing.T) {
+	recordedTime := time.Unix(1531420618, 0)
+	tests := []struct {
+		name      string
+		token     string
+		signature string
+		timestamp string
+		body      string
+		now       time.Time
+		err       error
+	}{
+		{"valid signature", "", recordedSignature, recordedTimestamp, recordedBody, recordedTime, nil},
+		{"valid signature within window", "", recordedSignature, recordedTimestamp, recordedBody, recordedTime.Add(4 * time.Minute), nil},
+		{"valid signature with token fallback enabled", "xyzz0WbapA4vBCDEFasx0q6G", recordedSignature, recordedTimestamp, recordedBody, recordedTime, nil},
+		{"forged body", "", recordedSignature, recordedTimestamp, forgedBody, recordedTime, ErrInvalidSignature},
+		{"forged timestamp", "", recordedSignature, "1531420619", recordedBody, recordedTime, ErrInvalidSignature},
+		{"wrong version", "", "v1=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503", recordedTimestamp, recordedBody, recordedTime, ErrInvalidSignature},
+		{"not hex", "", "v0=zzzz", recordedTimestamp, recordedBody, recordedTime, ErrInvalidSignature},
+		{"replayed", "", recordedSignature, recordedTimestamp, recordedBody, recordedTime.Add(6 * time.Minute), ErrExpiredTimestamp},
+		{"from the future", "", recordedSignature, recordedTimestamp, recordedBody, recordedTime.Add(-6 * time.Minute), ErrExpiredTimestamp},
+		{"bad timestamp", "", recordedSignature, "yesterday", recordedBody, recordedTime, ErrInvalidTimestamp},
+		{"missing signature", "", "", recordedTimestamp, recordedBody, recordedTime, ErrMissingHeaders},
+		{"missing headers", "", "", "", recordedBody, recordedTime, ErrMissingHeaders},
+		{"token fallback", "xyzz0WbapA4vBCDEFasx0q6G", "", "", recordedBody, recordedTime, nil},
+		{"token fallback wrong token", "another-token", "", "", recordedBody, recordedTime, ErrInvalidToken},
+		{"token fallback in interaction payload", "xyzz0WbapA4vBCDEFasx0q6G", "", "", "payload=%7B%22type%22%3A%22block_actions%22%2C%22token%22%3A%22xyzz0WbapA4vBCDEFasx0q6G%22%7D", recordedTime, nil},
+		{"token fallback ignored when signed", "xyzz0WbapA4vBCDEFasx0q6G", recordedSignature, recordedTimestamp, forgedBody, recordedTime, ErrInvalidSignature},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/", strings.NewReader(test.body))
+			if test.signature != "" {
+				r.Header.Set("X-Slack-Signature", test.signature)
+			}
+			if test.timestamp != "" {
+				r.Header.Set("X-Slack-Request-Timestamp", test.timestamp)
+			}
+			verifier := NewVerifier(recordedSecret, test.token)
+			now := test.now
+			verifier.Now = func() time.Time { return now }
+			require.Equal(t, test.err, verifier.Verify(r))
+		})
+	}
+}
+
+func TestVerifyLeavesBodyReadable(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader(recordedBody))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Slack-Signature", recordedSignature)
+	r.Header.Set("X-Slack-Request-Timestamp", recordedTimestamp)
+	verifier := NewVerifier(recordedSecret, "")
+	verifier.Now = func() time.Time { return time.Unix(1531420618, 0) }
+	require.Nil(t, verifier.Verify(r))
+	require.Nil(t, r.ParseForm())
+	require.Equal(t, "/webhook-collect", r.PostForm.Get("command"))
+}